@@ -8,4 +8,5 @@ require (
 	github.com/urfave/cli/v2 v2.3.0
 	golang.org/x/mod v0.5.1
 	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Classes of version change, from lowest to highest risk.
+// Pre-release and pseudo-version targets are classed as such regardless of the version components that change.
+// Semantic versioning makes no compatibility promise below v1, so from a v0 version, a minor version increase
+// is classed as major, as is any increase from a v0.0 version.
+const (
+	BumpNone       = "none"       // The versions are equal (or the target is lower)
+	BumpPatch      = "patch"      // The patch version increases
+	BumpMinor      = "minor"      // The minor version increases
+	BumpMajor      = "major"      // The major version increases, or a v0 version's minor version
+	BumpPrerelease = "prerelease" // The target is a pre-release version
+	BumpPseudo     = "pseudo"     // The target is a pseudo-version, identifying an untagged commit
+)
+
+// Classifies the change from one version to another.
+func ClassifyBump(from, to string) string {
+	switch {
+	case semver.Compare(to, from) <= 0:
+		return BumpNone
+	case module.IsPseudoVersion(to):
+		return BumpPseudo
+	case semver.Prerelease(to) != "":
+		return BumpPrerelease
+	case semver.Major(to) != semver.Major(from):
+		return BumpMajor
+	case semver.MajorMinor(to) != semver.MajorMinor(from) && semver.Major(from) == "v0":
+		return BumpMajor
+	case semver.MajorMinor(to) != semver.MajorMinor(from):
+		return BumpMinor
+	case semver.MajorMinor(from) == "v0.0":
+		return BumpMajor
+	default:
+		return BumpPatch
+	}
+}
+
+// Checks that a maximum bump is one that upgrades may be restricted to.
+func checkMaxBump(max string) error {
+	if max != "" && max != BumpPatch && max != BumpMinor {
+		return fmt.Errorf("unsupported maximum version bump %s, expected %s or %s", max, BumpPatch, BumpMinor)
+	}
+	return nil
+}
+
+// Checks whether a change from one version to another is within a maximum bump, patch or minor.
+// Pre-release and pseudo-version targets are never within a maximum.
+func bumpAllowed(from, to, max string) bool {
+	switch ClassifyBump(from, to) {
+	case BumpPatch:
+		return true
+	case BumpMinor:
+		return max == BumpMinor
+	default:
+		return false
+	}
+}
+
+// Selects the highest of the available versions that is above from, no higher than to, and allowed.
+// Returns "" if there is no such version.
+func highestAllowed(available []string, from, to string, allowed func(string) bool) string {
+	best := ""
+	candidates := append([]string{to}, available...)
+	for _, v := range candidates {
+		if semver.Compare(v, from) > 0 && semver.Compare(v, to) <= 0 && allowed(v) && semver.Compare(v, best) > 0 {
+			best = v
+		}
+	}
+	return best
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/anorth/rehab/internal/config"
+)
+
+func TestClassifyBump(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		expected string
+	}{
+		{"v1.2.3", "v1.2.3", BumpNone},
+		{"v1.2.3", "v1.2.2", BumpNone},
+		{"v1.2.3", "v1.2.4", BumpPatch},
+		{"v1.2.3", "v1.3.0", BumpMinor},
+		{"v1.2.3", "v2.0.0+incompatible", BumpMajor},
+		{"v1.2.3", "v1.3.0-rc.1", BumpPrerelease},
+		{"v1.2.3", "v1.2.4-0.20210101000000-abcdefabcdef", BumpPseudo},
+		{"v1.2.4-0.20210101000000-abcdefabcdef", "v1.2.4", BumpPatch},
+		{"v1.3.0-rc.1", "v1.3.0", BumpPatch},
+		// Below v1, a minor version change is major, as is any change from v0.0.
+		{"v0.1.2", "v0.1.3", BumpPatch},
+		{"v0.1.2", "v0.2.0", BumpMajor},
+		{"v0.0.14", "v0.0.15", BumpMajor},
+		{"v0.0.14", "v0.1.0", BumpMajor},
+		{"v0.7.1-0.20210125211748-8d37030e16e1", "v0.14.3", BumpMajor},
+		{"v0.9.0", "v1.0.0", BumpMajor},
+		{"v0.0.1", "v0.0.2-pre", BumpPrerelease},
+	} {
+		if actual := ClassifyBump(tc.from, tc.to); actual != tc.expected {
+			t.Errorf("%s → %s: %s, expected %s", tc.from, tc.to, actual, tc.expected)
+		}
+	}
+}
+
+func TestBumpAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, max string
+		expected      bool
+	}{
+		{"v1.2.3", "v1.2.4", BumpPatch, true},
+		{"v1.2.3", "v1.2.4", BumpMinor, true},
+		{"v1.2.3", "v1.3.0", BumpPatch, false},
+		{"v1.2.3", "v1.3.0", BumpMinor, true},
+		{"v1.2.3", "v2.0.0+incompatible", BumpMinor, false},
+		{"v1.2.3", "v1.2.4-rc.1", BumpMinor, false},
+		{"v1.2.3", "v1.2.4-0.20210101000000-abcdefabcdef", BumpMinor, false},
+		{"v1.2.3", "v1.2.3", BumpMinor, false},
+		{"v0.1.2", "v0.1.3", BumpPatch, true},
+		{"v0.1.2", "v0.2.0", BumpMinor, false},
+		{"v0.0.1", "v0.0.2", BumpMinor, false},
+	} {
+		if actual := bumpAllowed(tc.from, tc.to, tc.max); actual != tc.expected {
+			t.Errorf("%s → %s within %s: %v, expected %v", tc.from, tc.to, tc.max, actual, tc.expected)
+		}
+	}
+}
+
+func TestHighestAllowed(t *testing.T) {
+	available := []string{"v1.2.3", "v1.2.4", "v1.2.5-rc.1", "v1.3.0", "v1.3.1", "v1.4.0", "v1.4.2", "v1.5.0"}
+	for _, tc := range []struct {
+		name     string
+		from, to string
+		max      string // Maximum bump, if any
+		pin      string // Pinned version, if any
+		expected string
+	}{
+		{name: "unrestricted", from: "v1.2.3", to: "v1.5.0", expected: "v1.5.0"},
+		{name: "patch", from: "v1.2.3", to: "v1.5.0", max: BumpPatch, expected: "v1.2.4"},
+		{name: "minor", from: "v1.2.3", to: "v1.5.0", max: BumpMinor, expected: "v1.5.0"},
+		{name: "below target", from: "v1.2.3", to: "v1.3.0", max: BumpMinor, expected: "v1.3.0"},
+		{name: "no patch release", from: "v1.3.1", to: "v1.5.0", max: BumpPatch, expected: ""},
+		{name: "pin", from: "v1.2.3", to: "v1.5.0", pin: "v1.3", expected: "v1.3.1"},
+		{name: "pin exact", from: "v1.2.3", to: "v1.5.0", pin: "v1.4.0", expected: "v1.4.0"},
+		{name: "pin and minor", from: "v1.2.3", to: "v1.5.0", max: BumpMinor, pin: "v1.4", expected: "v1.4.2"},
+		{name: "pin and patch", from: "v1.2.3", to: "v1.5.0", max: BumpPatch, pin: "v1.4", expected: "v1.2.4"},
+		{name: "pin below from", from: "v1.3.0", to: "v1.5.0", max: BumpMinor, pin: "v1.2", expected: ""},
+		// The target itself is a candidate, even if not listed as available.
+		{name: "unlisted target", from: "v1.5.0", to: "v1.5.1", max: BumpPatch, expected: "v1.5.1"},
+	} {
+		pin := &config.Pin{Module: "example.com/a", Version: tc.pin}
+		actual := highestAllowed(available, tc.from, tc.to, func(v string) bool {
+			return (tc.max == "" || bumpAllowed(tc.from, v, tc.max)) && (tc.pin == "" || pin.Allows(v))
+		})
+		if actual != tc.expected {
+			t.Errorf("%s: %s → %s: %q, expected %q", tc.name, tc.from, tc.to, actual, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/pkg/model"
+)
+
+type DeprecatedModule struct {
+	Module    *model.ModuleInfo          // The deprecated module, at its selected version
+	Consumers []model.ModuleRelationship // Requirements on the module from module versions in the build list
+}
+
+func (dm *DeprecatedModule) String() string {
+	consumers := make([]string, len(dm.Consumers))
+	for i, c := range dm.Consumers {
+		consumers[i] = fmt.Sprintf("%s (requires %s)", c.Downstream, c.Upstream.Version)
+	}
+	return fmt.Sprintf("%s is deprecated: %s\n  required by %s",
+		dm.Module.Path, dm.Module.Deprecated, strings.Join(consumers, ", "))
+}
+
+// Finds modules in the build list whose authors have deprecated them, with the module versions in the
+// build list that still require them.
+func FindDeprecatedModules(modules *db.Modules, modGraph *db.ModGraph) []*DeprecatedModule {
+	buildList := modGraph.BuildList(modules.MainVersions()...)
+	var found []*DeprecatedModule
+	for _, mod := range modules.All() {
+		if mod.Deprecated == "" {
+			continue
+		}
+		dm := &DeprecatedModule{Module: mod}
+		for _, req := range modGraph.DownstreamOf(mod.Path, "") {
+			if consumer, ok := buildList.Selected(req.Downstream.Path); ok && consumer.Module == req.Downstream {
+				dm.Consumers = append(dm.Consumers, req)
+			}
+		}
+		sort.Slice(dm.Consumers, func(i, j int) bool {
+			return dm.Consumers[i].Downstream.Path < dm.Consumers[j].Downstream.Path
+		})
+		found = append(found, dm)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Module.Path < found[j].Module.Path
+	})
+	return found
+}
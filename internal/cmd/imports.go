@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// An import of a package in one module by a package in another.
+type PackageLink struct {
+	Importer string // Import path of the importing package
+	Imported string // Import path of the imported package
+	Direct   bool   // Whether the import is direct, rather than through other packages
+}
+
+func (pl PackageLink) String() string {
+	if pl.Direct {
+		return fmt.Sprintf("%s imports %s", pl.Importer, pl.Imported)
+	}
+	return fmt.Sprintf("%s depends on %s", pl.Importer, pl.Imported)
+}
+
+// Filters stale requirements to those where the consuming module's packages import packages from the required
+// module, either directly or through other packages. The links between packages are recorded in each stale
+// version kept.
+// The packages are those in the build of the main module, so only consumer packages in that build are considered.
+func FilterImported(stale []*StaleVersion, packages []*model.PackageInfo) []*StaleVersion {
+	packagesByModule := map[string][]*model.PackageInfo{}
+	moduleOfPackage := map[string]string{}
+	for _, pkg := range packages {
+		if pkg.Module == nil {
+			continue // Standard library
+		}
+		packagesByModule[pkg.Module.Path] = append(packagesByModule[pkg.Module.Path], pkg)
+		moduleOfPackage[pkg.ImportPath] = pkg.Module.Path
+	}
+
+	var result []*StaleVersion
+	for _, s := range stale {
+		var direct, indirect []PackageLink
+		for _, pkg := range packagesByModule[s.Consumer.Path] {
+			for _, imp := range pkg.Imports {
+				if moduleOfPackage[imp] == s.Requirement.Path {
+					direct = append(direct, PackageLink{Importer: pkg.ImportPath, Imported: imp, Direct: true})
+				}
+			}
+			if len(direct) > 0 {
+				continue
+			}
+			for _, dep := range pkg.Deps {
+				if moduleOfPackage[dep] == s.Requirement.Path {
+					indirect = append(indirect, PackageLink{Importer: pkg.ImportPath, Imported: dep, Direct: false})
+				}
+			}
+		}
+		links := direct
+		if len(links) == 0 {
+			links = indirect
+		}
+		if len(links) == 0 {
+			continue
+		}
+		sort.Slice(links, func(i, j int) bool {
+			if links[i].Importer != links[j].Importer {
+				return links[i].Importer < links[j].Importer
+			}
+			return links[i].Imported < links[j].Imported
+		})
+		s.Links = links
+		result = append(result, s)
+	}
+	return result
+}
+
+// Summarises package links for display, showing at most a few.
+func summariseLinks(links []PackageLink) string {
+	const shown = 3
+	var descriptions []string
+	for i, l := range links {
+		if i == shown {
+			descriptions = append(descriptions, fmt.Sprintf("and %d more", len(links)-shown))
+			break
+		}
+		descriptions = append(descriptions, l.String())
+	}
+	return strings.Join(descriptions, ", ")
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/anorth/rehab/internal/remote"
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Title of issues reporting stale requirements.
+const issueTitle = "Stale module requirements"
+
+// Returns the marker identifying the issue reporting a module's stale requirements, so that later runs update
+// the same issue. The marker is an HTML comment, invisible in the rendered issue.
+func issueMarker(modulePath string) string {
+	return fmt.Sprintf("<!-- rehab:stale-requirements %s -->", modulePath)
+}
+
+// Opens or updates an issue on a module's repository listing its stale requirements, returning the issue's URL.
+// If there are none, any issue previously opened is closed, and its URL returned (or "" if there was none).
+func (app *Rehab) fileIssue(ctx context.Context, opts remote.Options, mains []model.ModuleVersion, module *model.ModuleInfo, ups []*upgrade) (string, error) {
+	log.Printf("filing issue for %s", module.Path)
+	repo, err := remote.Open(ctx, module.Path, opts)
+	if err != nil {
+		return "", err
+	}
+	defer repo.Close()
+	tracker, ok := repo.(remote.IssueTracker)
+	if !ok {
+		return "", fmt.Errorf("issues aren't supported for %s at %s", module.Path, repo.URL())
+	}
+	if len(ups) == 0 {
+		return tracker.CloseIssue(ctx, issueMarker(module.Path), resolvedIssueDescription(mains, module.Path))
+	}
+	return tracker.MakeIssue(ctx, issueMarker(module.Path), issueTitleFor(module.Path, repo.Dir()),
+		issueDescription(mains, module.Path, ups))
+}
+
+// Returns the title of a module's issue, naming the module if it's not at the repository root.
+func issueTitleFor(modulePath, dir string) string {
+	if dir != "" {
+		return issueTitle + " for " + modulePath
+	}
+	return issueTitle
+}
+
+// Describes a consumer with no stale requirements, replacing the description of a closed issue.
+func resolvedIssueDescription(mains []model.ModuleVersion, consumer string) string {
+	var builds []string
+	for _, m := range mains {
+		builds = append(builds, "`"+m.Path+"`")
+	}
+	return fmt.Sprintf("%s\nNo module requirements of `%s` are stale when building %s.\n\n"+
+		"This is an automated issue maintained by Rehab, and reopened as a new issue if requirements become stale.",
+		issueMarker(consumer), consumer, strings.Join(builds, ", "))
+}
+
+// Describes a consumer's stale requirements for an issue, as a table of the declared, selected and highest
+// versions of each requirement, the module forcing the selected version, and the proposed upgrade.
+func issueDescription(mains []model.ModuleVersion, consumer string, ups []*upgrade) string {
+	var builds []string
+	for _, m := range mains {
+		builds = append(builds, "`"+m.Path+"`")
+	}
+	var b strings.Builder
+	b.WriteString(issueMarker(consumer) + "\n")
+	fmt.Fprintf(&b, "The following module requirements of `%s` are stale. Where a requirement is older than the "+
+		"version selected by MVS when building %s, tests of `%s` run against a different version than those builds use.\n\n",
+		consumer, strings.Join(builds, ", "), consumer)
+	b.WriteString("| Module | Required | Selected | Selected via | Highest | Upgrade |\n")
+	b.WriteString("|---|---|---|---|---|---|\n")
+	var notes []string
+	for _, u := range ups {
+		via := ""
+		if u.SelectedReason.Path != "" && u.SelectedReason.Path != consumer {
+			via = "`" + u.SelectedReason.String() + "`"
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s | %s | %s (%s) |\n", u.Requirement.Path, u.Requirement.Version,
+			u.SelectedVersion, via, u.HighestVersion, u.Version, u.Bump())
+
+		if u.Retracted != nil {
+			notes = append(notes, fmt.Sprintf("%s is retracted: %s", u.Requirement, retractionRationale(u.Retracted)))
+		}
+		if u.SelectedRetracted != nil && u.SelectedVersion != u.Requirement.Version {
+			notes = append(notes, fmt.Sprintf("%s@%s is retracted: %s", u.Requirement.Path, u.SelectedVersion,
+				retractionRationale(u.SelectedRetracted)))
+		}
+		if u.Deprecated != "" {
+			notes = append(notes, fmt.Sprintf("%s is deprecated: %s", u.Requirement.Path, u.Deprecated))
+		}
+		if u.Metrics != nil {
+			notes = append(notes, fmt.Sprintf("%s is %s", u.Requirement, u.Metrics))
+		}
+		if u.Pin != nil {
+			notes = append(notes, fmt.Sprintf("%s upgrades are %s", u.Requirement.Path, u.Pin))
+		}
+	}
+	if len(notes) > 0 {
+		b.WriteString("\n")
+		for _, n := range notes {
+			fmt.Fprintf(&b, "- %s\n", n)
+		}
+	}
+	b.WriteString("\nThis is an automated issue maintained by Rehab, and updated when it runs again.")
+	return b.String()
+}
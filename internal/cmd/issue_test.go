@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anorth/rehab/pkg/model"
+)
+
+func mv(t *testing.T, s string) model.ModuleVersion {
+	var v model.ModuleVersion
+	if err := v.Parse(s); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestIssueDescription(t *testing.T) {
+	mains := []model.ModuleVersion{mv(t, "example.com/main"), mv(t, "example.com/tool")}
+	ups := []*upgrade{
+		{StaleVersion: &StaleVersion{
+			Consumer:        mv(t, "example.com/a@v1.0.0"),
+			Requirement:     mv(t, "example.com/b@v1.0.0"),
+			SelectedVersion: "v1.2.0",
+			SelectedReason:  mv(t, "example.com/c@v1.1.0"),
+			HighestVersion:  "v1.3.0",
+			Retracted:       []string{},
+		}, Version: "v1.3.0"},
+		{StaleVersion: &StaleVersion{
+			Consumer:          mv(t, "example.com/a@v1.0.0"),
+			Requirement:       mv(t, "example.com/d@v0.1.0"),
+			SelectedVersion:   "v0.2.0",
+			SelectedReason:    mv(t, "example.com/a@v1.0.0"),
+			HighestVersion:    "v0.3.0",
+			SelectedRetracted: []string{"broken build"},
+			Deprecated:        "use example.com/e",
+		}, Version: "v0.2.0"},
+	}
+	d := issueDescription(mains, "example.com/a", ups)
+	for _, expected := range []string{
+		issueMarker("example.com/a") + "\n",
+		"when building `example.com/main`, `example.com/tool`, tests of `example.com/a`",
+		"| `example.com/b` | v1.0.0 | v1.2.0 | `example.com/c@v1.1.0` | v1.3.0 | v1.3.0 (minor) |\n",
+		// The consumer isn't named as the module forcing the selected version.
+		"| `example.com/d` | v0.1.0 | v0.2.0 |  | v0.3.0 | v0.2.0 (major) |\n",
+		"- example.com/b@v1.0.0 is retracted: no rationale given\n",
+		"- example.com/d@v0.2.0 is retracted: broken build\n",
+		"- example.com/d is deprecated: use example.com/e\n",
+	} {
+		if !strings.Contains(d, expected) {
+			t.Errorf("description missing %q:\n%s", expected, d)
+		}
+	}
+
+	// A description without notes has no list.
+	ups[0].Retracted = nil
+	if d = issueDescription(mains, "example.com/a", ups[:1]); strings.Contains(d, "\n- ") {
+		t.Errorf("unexpected notes:\n%s", d)
+	}
+
+	resolved := resolvedIssueDescription(mains, "example.com/a")
+	if !strings.HasPrefix(resolved, issueMarker("example.com/a")) || strings.Contains(resolved, "|") {
+		t.Errorf("resolved description:\n%s", resolved)
+	}
+}
+
+func TestIssueTitle(t *testing.T) {
+	if title := issueTitleFor("example.com/a", ""); title != issueTitle {
+		t.Errorf("title %q", title)
+	}
+	if title := issueTitleFor("example.com/a/sub", "sub"); title != issueTitle+" for example.com/a/sub" {
+		t.Errorf("title %q", title)
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anorth/rehab/internal/fetch"
+	"github.com/anorth/rehab/pkg/model"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+type MajorVersion struct {
+	Module model.ModuleVersion // A module version in the build
+	Latest model.ModuleVersion // The latest version at the highest newer major version's module path
+}
+
+func (mv *MajorVersion) String() string {
+	return fmt.Sprintf("%s has new major version %s", mv.Module, mv.Latest)
+}
+
+// Finds newer major versions of modules, which have distinct module paths, by probing successive
+// major version module paths through the module proxy.
+func (app *Rehab) findMajorVersions(root string, mods []model.ModuleVersion) ([]*MajorVersion, error) {
+	// The module path being probed for each module, and the latest version found at a newer major version.
+	probing := map[model.ModuleVersion]string{}
+	found := map[model.ModuleVersion]model.ModuleVersion{}
+	for _, mod := range mods {
+		if next, ok := nextMajorPath(mod.Path, mod.Version); ok {
+			probing[mod] = next
+		}
+	}
+	for len(probing) > 0 {
+		var queries []model.ModuleVersion
+		for _, next := range probing {
+			queries = append(queries, model.ModuleVersion{Path: next, Version: "latest"})
+		}
+		infos, err := fetch.ListModuleVersions(root, queries)
+		if err != nil {
+			return nil, err
+		}
+		latest := map[string]string{}
+		for _, info := range infos {
+			if info.Error == nil {
+				latest[info.Path] = info.Version
+			}
+		}
+		for mod, next := range probing {
+			v, ok := latest[next]
+			if !ok {
+				delete(probing, mod)
+				continue
+			}
+			found[mod] = model.ModuleVersion{Path: next, Version: v}
+			if probing[mod], ok = nextMajorPath(next, v); !ok {
+				delete(probing, mod)
+			}
+		}
+	}
+
+	var result []*MajorVersion
+	for mod, latest := range found {
+		result = append(result, &MajorVersion{Module: mod, Latest: latest})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Module.Path < result[j].Module.Path
+	})
+	return result, nil
+}
+
+// Returns the module path for the major version following that of a module version.
+// For a +incompatible version vN, which predates the module adopting a /vN path, that is the /vN path itself.
+func nextMajorPath(modPath, version string) (string, bool) {
+	prefix, pathMajor, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return "", false
+	}
+	major, err := strconv.Atoi(strings.TrimPrefix(semver.Major(version), "v"))
+	if err != nil {
+		return "", false
+	}
+	if major < 1 {
+		major = 1
+	}
+	if strings.HasPrefix(pathMajor, ".") { // gopkg.in
+		return fmt.Sprintf("%s.v%d", prefix, major+1), true
+	}
+	if pathMajor == "" && semver.Build(version) == "+incompatible" {
+		return fmt.Sprintf("%s/v%d", prefix, major), true
+	}
+	return fmt.Sprintf("%s/v%d", prefix, major+1), true
+}
+
+// Replaces requirements on modules with requirements on their newer major versions, in go.mod file content.
+func upgradeGoModMajor(name string, original []byte, majors []*MajorVersion) ([]byte, error) {
+	modFile, err := modfile.Parse(name, original, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing go.mod: %w", err)
+	}
+	for _, mv := range majors {
+		if err := modFile.DropRequire(mv.Module.Path); err != nil {
+			return nil, err
+		}
+		if err := modFile.AddRequire(mv.Latest.Path, mv.Latest.Version); err != nil {
+			return nil, err
+		}
+	}
+	modFile.Cleanup()
+	newContent, err := modFile.Format()
+	if err != nil {
+		return nil, fmt.Errorf("failed to format new go.mod file: %w", err)
+	}
+	return newContent, nil
+}
+
+// Rewrites imports of packages from old major version module paths to the new major version paths in the
+// .go files of a module directory, excluding nested modules. Returns the paths of files changed.
+func rewriteMajorImports(moduleDir string, majors []*MajorVersion) ([]string, error) {
+	var changed []string
+	err := filepath.Walk(moduleDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p == moduleDir {
+				return nil
+			}
+			name := info.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".go") {
+			return nil
+		}
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rewritten, err := rewriteImports(p, src, majors)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(src, rewritten) {
+			if err := ioutil.WriteFile(p, rewritten, info.Mode()); err != nil {
+				return err
+			}
+			changed = append(changed, p)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// Rewrites the import paths in Go source that refer to packages of old major version modules.
+func rewriteImports(filename string, src []byte, majors []*MajorVersion) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", filename, err)
+	}
+	var result bytes.Buffer
+	last := 0
+	for _, spec := range f.Imports {
+		importPath, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		for _, mv := range majors {
+			if isPackageOf(importPath, mv.Module.Path) {
+				start := fset.Position(spec.Path.Pos()).Offset
+				end := fset.Position(spec.Path.End()).Offset
+				result.Write(src[last:start])
+				result.WriteString(strconv.Quote(mv.Latest.Path + strings.TrimPrefix(importPath, mv.Module.Path)))
+				last = end
+				break
+			}
+		}
+	}
+	result.Write(src[last:])
+	return result.Bytes(), nil
+}
+
+// Checks whether an import path names a package in a module's root or a subdirectory, and not in a different
+// major version of that module.
+func isPackageOf(importPath, modPath string) bool {
+	if importPath == modPath {
+		return true
+	}
+	if !strings.HasPrefix(importPath, modPath+"/") {
+		return false
+	}
+	next := strings.SplitN(strings.TrimPrefix(importPath, modPath+"/"), "/", 2)[0]
+	_, pathMajor, ok := module.SplitPathVersion(modPath + "/" + next)
+	return !ok || pathMajor == ""
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import "testing"
+
+func TestNextMajorPath(t *testing.T) {
+	for _, tc := range []struct {
+		modPath, version string
+		expected         string
+	}{
+		{"example.com/a", "v0.3.0", "example.com/a/v2"},
+		{"example.com/a", "v1.2.0", "example.com/a/v2"},
+		{"example.com/a/v2", "v2.0.1", "example.com/a/v3"},
+		{"example.com/a/v3", "v3.1.0-pre", "example.com/a/v4"},
+		// An incompatible version may be followed by a module at the same major version.
+		{"example.com/a", "v3.2.0+incompatible", "example.com/a/v3"},
+		{"example.com/a", "v2.0.0+incompatible", "example.com/a/v2"},
+		{"gopkg.in/yaml.v2", "v2.4.0", "gopkg.in/yaml.v3"},
+	} {
+		next, ok := nextMajorPath(tc.modPath, tc.version)
+		if !ok || next != tc.expected {
+			t.Errorf("%s@%s: next %q (%v), expected %q", tc.modPath, tc.version, next, ok, tc.expected)
+		}
+	}
+	if next, ok := nextMajorPath("example.com/a/v2", "bad"); ok {
+		t.Errorf("next %q for bad version", next)
+	}
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/pkg/model"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Average length of a year, for converting durations to libyears.
+const year = time.Duration(365.25 * 24 * float64(time.Hour))
+
+// Measures of how far a requirement lags behind the highest version of the required module.
+type Staleness struct {
+	VersionsBehind int     // Number of releases after the declared version, up to and including the highest version
+	Libyears       float64 // Years between the release of the declared version and of the highest version
+	Dated          bool    // Whether the release times of both versions are known, and so Libyears is meaningful
+}
+
+func (s Staleness) String() string {
+	if !s.Dated {
+		return fmt.Sprintf("%d releases behind", s.VersionsBehind)
+	}
+	return fmt.Sprintf("%d releases, %.1f libyears behind", s.VersionsBehind, s.Libyears)
+}
+
+// Totals of staleness across the stale requirements of a single consumer.
+type ConsumerStaleness struct {
+	Consumer     model.ModuleVersion
+	Requirements int // Number of stale requirements
+	Staleness
+}
+
+func (cs *ConsumerStaleness) String() string {
+	return fmt.Sprintf("%s: %d stale requirements, %s", cs.Consumer, cs.Requirements, cs.Staleness)
+}
+
+// Measures the staleness of each stale requirement.
+// The modules must have been listed with their available versions, and the versions database provides the
+// release time of declared requirement versions. Either may be missing information, in which case the measures
+// are partial.
+func MeasureStaleness(stale []*StaleVersion, modules *db.Modules, versions *db.ModuleVersions) {
+	for _, s := range stale {
+		info, err := modules.ForPath(s.Requirement.Path)
+		if err != nil {
+			continue
+		}
+		m := &Staleness{VersionsBehind: versionsBetween(info.Versions, s.Requirement.Version, s.HighestVersion)}
+		declared := versionTime(info, versions, s.Requirement.Version)
+		highest := versionTime(info, versions, s.HighestVersion)
+		if declared != nil && highest != nil {
+			m.Dated = true
+			if highest.After(*declared) {
+				m.Libyears = float64(highest.Sub(*declared)) / float64(year)
+			}
+		}
+		s.Metrics = m
+	}
+}
+
+// Sums the measured staleness of requirements for each consumer, ordered by descending libyears
+// and then releases behind.
+func TotalStaleness(stale []*StaleVersion) []*ConsumerStaleness {
+	byConsumer := map[model.ModuleVersion]*ConsumerStaleness{}
+	var totals []*ConsumerStaleness
+	for _, s := range stale {
+		if s.Metrics == nil {
+			continue
+		}
+		t, ok := byConsumer[s.Consumer]
+		if !ok {
+			t = &ConsumerStaleness{Consumer: s.Consumer, Staleness: Staleness{Dated: true}}
+			byConsumer[s.Consumer] = t
+			totals = append(totals, t)
+		}
+		t.Requirements++
+		t.VersionsBehind += s.Metrics.VersionsBehind
+		t.Libyears += s.Metrics.Libyears
+		t.Dated = t.Dated && s.Metrics.Dated
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Libyears != totals[j].Libyears {
+			return totals[i].Libyears > totals[j].Libyears
+		}
+		if totals[i].VersionsBehind != totals[j].VersionsBehind {
+			return totals[i].VersionsBehind > totals[j].VersionsBehind
+		}
+		return totals[i].Consumer.Path < totals[j].Consumer.Path
+	})
+	return totals
+}
+
+// Counts the available versions after from, up to and including to.
+// A target version that isn't in the list (e.g. a pseudo-version) counts as one more release.
+func versionsBetween(available []string, from, to string) int {
+	count := 0
+	listed := false
+	for _, v := range available {
+		if semver.Compare(v, from) > 0 && semver.Compare(v, to) <= 0 {
+			count++
+		}
+		listed = listed || v == to
+	}
+	if !listed && semver.Compare(to, from) > 0 {
+		count++
+	}
+	return count
+}
+
+// Finds the release time of a version of a module, from the module's listed information or the versions database.
+// The time of a pseudo-version is that of its commit, encoded in the version.
+func versionTime(info *model.ModuleInfo, versions *db.ModuleVersions, version string) *time.Time {
+	if module.IsPseudoVersion(version) {
+		if t, err := module.PseudoVersionTime(version); err == nil {
+			return &t
+		}
+	}
+	if info.Version == version && info.Time != nil {
+		return info.Time
+	}
+	if info.Update != nil && info.Update.Version == version && info.Update.Time != nil {
+		return info.Update.Time
+	}
+	if versions != nil {
+		if vi, ok := versions.ForVersion(model.ModuleVersion{Path: info.Path, Version: version}); ok && vi.Error == nil {
+			return vi.Time
+		}
+	}
+	return nil
+}
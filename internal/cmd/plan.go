@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plans upgrades of all stale requirements in the graph as ordered rounds. In each round, the modules are upgraded
+// and then new releases of them tagged, so that the next round can upgrade to those releases.
+// Prints the rounds and the number of rounds needed to bring the whole graph to head.
+func (app *Rehab) Plan(root string) error {
+	modules, err := app.fetchModules(root)
+	if err != nil {
+		return err
+	}
+	modGraph, err := app.fetchModGraph(root)
+	if err != nil {
+		return err
+	}
+	stale, _, err := app.findStale(root, modules, modGraph, "")
+	if err != nil {
+		return err
+	}
+	buildList := modGraph.BuildList(modules.MainVersions()...)
+
+	var changed []string
+	for _, s := range stale {
+		changed = append(changed, s.Consumer.Path)
+	}
+	rounds := modGraph.PropagationRounds(buildList, changed)
+	if len(rounds) == 0 {
+		fmt.Println("No stale requirements, the graph is at head")
+		return nil
+	}
+	for i, round := range rounds {
+		var releases []string
+		for _, m := range round {
+			if !modules.IsMain(m) {
+				releases = append(releases, m)
+			}
+		}
+		fmt.Printf("Round %d:\n  upgrade %s\n", i+1, strings.Join(round, ", "))
+		if len(releases) > 0 {
+			fmt.Printf("  then tag releases of %s\n", strings.Join(releases, ", "))
+		}
+	}
+	fmt.Printf("%d rounds to bring the graph to head\n", len(rounds))
+	return nil
+}
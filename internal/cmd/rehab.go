@@ -1,28 +1,66 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
 	"sort"
 	"strings"
 
+	"github.com/anorth/rehab/internal/config"
 	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/internal/diff"
 	"github.com/anorth/rehab/internal/fetch"
 	"github.com/anorth/rehab/internal/remote"
 	"github.com/anorth/rehab/pkg/model"
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// Policies for stale requirements on modules that are replaced in the main module's build.
+const (
+	ReplacedSkip    = "skip"    // Neither report nor upgrade the requirement
+	ReplacedReport  = "report"  // Report the requirement, but don't upgrade it
+	ReplacedUpgrade = "upgrade" // Report and upgrade the requirement, unless replaced by a local directory
 )
 
 type Rehab struct {
-	GitHubToken      string // GitHub authentication token
-	MinimumUpgrade   bool   // Restrict upgrades to MVS-selected version, rather than latest
-	BranchPrefix     string // Prefix for branches pushed to GitHub
-	MakePullRequests bool   // Initiate pull requests (rather than only pushing branches)
-	Verbose          bool   // Whether to log progress
+	GitHubToken      string            // GitHub authentication token
+	GitLabToken      string            // GitLab authentication token
+	GitLabHosts      []string          // Hosts of GitLab servers, in addition to gitlab.com
+	GitRemotes       map[string]string // Plain git URLs for module path prefixes, used instead of hosts' APIs
+	ForkOrg          string            // GitHub organization for forks of repositories the token can't push to
+	FileIssues       bool              // Open issues listing stale requirements rather than pushing upgrades
+	MinimumUpgrade   bool              // Restrict upgrades to MVS-selected version, rather than latest
+	BranchPrefix     string            // Prefix for branches pushed to GitHub
+	MakePullRequests bool              // Initiate pull requests (rather than only pushing branches)
+	Verbose          bool              // Whether to log progress
+	DryRun           bool              // Print proposed changes rather than pushing them
+	ReplacedPolicy   string            // Policy for requirements on replaced modules, one of the Replaced* constants
+	MajorUpgrade     bool              // Upgrade requirements to new major versions, rewriting imports (local only)
+	ImportedOnly     bool              // Consider only stale requirements where the consumer imports the required module's packages
+	Metrics          bool              // Measure how far stale requirements lag, loading all available module versions
+	MaxBump          string            // Restrict upgrades to the highest version within a bump, BumpPatch or BumpMinor, if set
+	Config           *config.Config    // Rules for traversal, reporting and upgrades; the default configuration if nil
 }
 
-func (app *Rehab) Show(root string, all bool) error {
+// Options for sections of output from Show.
+type ShowOptions struct {
+	All        bool // Show stale requirements of all modules in the graph, not only the main module
+	Deprecated bool // Show deprecated modules in the graph and the modules requiring them
+	Major      bool // Show newer major versions of required modules
+}
+
+// Shows stale requirements of the main module, or of all modules in the graph, with other optional sections.
+func (app *Rehab) Show(root string, opts ShowOptions) error {
 	modules, err := app.fetchModules(root)
 	if err != nil {
 		return err
@@ -31,21 +69,85 @@ func (app *Rehab) Show(root string, all bool) error {
 	if err != nil {
 		return err
 	}
-	mainModule := modules.Main()
-
-	stale := FindStaleVersions(modules, modGraph)
+	stale, ignored, err := app.findStale(root, modules, modGraph, "")
+	if err != nil {
+		return err
+	}
 	sort.Slice(stale, func(i, j int) bool {
 		return strings.Compare(stale[i].Consumer.Path, stale[j].Consumer.Path) < 0
 	})
 	for _, s := range stale {
-		if s.Consumer.Path == mainModule.Path || all {
+		if modules.IsMain(s.Consumer.Path) || opts.All {
 			fmt.Println(s)
 		}
 	}
+	var shownIgnored []*ignoredVersion
+	for _, ig := range ignored {
+		if modules.IsMain(ig.Consumer.Path) || opts.All {
+			shownIgnored = append(shownIgnored, ig)
+		}
+	}
+	if len(shownIgnored) > 0 {
+		fmt.Println()
+		fmt.Println("Ignored by configuration:")
+		for _, ig := range shownIgnored {
+			fmt.Println(ig)
+		}
+	}
+	if app.Metrics {
+		fmt.Println()
+		fmt.Println("Staleness by consumer:")
+		for _, t := range TotalStaleness(stale) {
+			if modules.IsMain(t.Consumer.Path) || opts.All {
+				fmt.Println(t)
+			}
+		}
+	}
+
+	if opts.Deprecated {
+		fmt.Println()
+		fmt.Println("Deprecated modules:")
+		for _, dm := range FindDeprecatedModules(modules, modGraph) {
+			fmt.Println(dm)
+		}
+	}
+	if opts.Major {
+		var mods []model.ModuleVersion
+		if opts.All {
+			for _, mod := range modules.All() {
+				if !mod.Main {
+					mods = append(mods, model.ModuleVersion{Path: mod.Path, Version: mod.Version})
+				}
+			}
+		} else {
+			mods = directRequirements(modules, modGraph)
+		}
+		majors, err := app.findMajorVersions(root, mods)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		fmt.Println("New major versions:")
+		for _, mv := range majors {
+			fmt.Println(mv)
+		}
+	}
 	return nil
 }
 
-func (app *Rehab) Propose(ctx context.Context, root string, all bool) error {
+// Proposes upgrades to stale requirements.
+// If of is non-empty, it names an upstream module (optionally with @version, otherwise its latest version) and
+// upgrades are proposed to every consumer in the graph requiring an older version of that module. Otherwise, upgrades are proposed for the main
+// module's requirements, or for all consumers in the graph.
+// If FileIssues is set, an issue listing the stale requirements is opened on each consumer's repository instead,
+// and a main module's issue is closed once it has none.
+func (app *Rehab) Propose(ctx context.Context, root string, all bool, of string) error {
+	if app.FileIssues && app.GitHubToken == "" && !app.DryRun {
+		return fmt.Errorf("a GitHub token is required to file issues")
+	}
+	if app.GitHubToken == "" && app.GitLabToken == "" && len(app.GitRemotes) == 0 && !app.DryRun {
+		return fmt.Errorf("a GitHub or GitLab token, or a git remote, is required to push upgrades")
+	}
 	modules, err := app.fetchModules(root)
 	if err != nil {
 		return err
@@ -54,47 +156,498 @@ func (app *Rehab) Propose(ctx context.Context, root string, all bool) error {
 	if err != nil {
 		return err
 	}
-	mainModule := modules.Main()
+	stale, _, err := app.findStale(root, modules, modGraph, of)
+	if err != nil {
+		return err
+	}
+	upgrades, err := app.selectUpgrades(modules, stale, all, of, false)
+	if err != nil {
+		return err
+	}
 
-	stale := FindStaleVersions(modules, modGraph)
-	// Proposed requirement upgrades keyed by consuming module
-	upgrades := map[string][]model.ModuleVersion{}
-	for _, s := range stale {
-		if s.Consumer.Path == mainModule.Path || all {
-			upgradeTo := s.HighestVersion
-			if app.MinimumUpgrade {
-				upgradeTo = s.SelectedVersion
+	// Propose upgrades off retracted versions first.
+	consumers := make([]string, 0, len(upgrades))
+	for modPath := range upgrades {
+		consumers = append(consumers, modPath)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		ri, rj := upgrades[consumers[i]][0].Retracted != nil, upgrades[consumers[j]][0].Retracted != nil
+		if ri != rj {
+			return ri
+		}
+		return consumers[i] < consumers[j]
+	})
+	remoteOpts := remote.Options{
+		GitHubToken: app.GitHubToken,
+		GitLabToken: app.GitLabToken,
+		GitLabHosts: app.GitLabHosts,
+		GitRemotes:  app.GitRemotes,
+		ForkOrg:     app.ForkOrg,
+		Resolver:    remote.NewResolver(app.configuration().RepoURLs()),
+	}
+	if app.FileIssues && of == "" {
+		// Close issues reporting since-upgraded requirements of main modules. Other consumers are reported only
+		// while they have stale requirements, so finding their issues would mean opening every module's remote.
+		for _, m := range modules.MainVersions() {
+			if _, ok := upgrades[m.Path]; !ok {
+				consumers = append(consumers, m.Path)
 			}
-			upgrades[s.Consumer.Path] = append(upgrades[s.Consumer.Path], model.ModuleVersion{
-				Path:  s.Requirement.Path,
-				Version: upgradeTo,
-			})
 		}
 	}
-
-	for modPath, reqs := range upgrades {
+	for _, modPath := range consumers {
+		ups := upgrades[modPath]
 		module, err := modules.ForPath(modPath)
 		if err != nil {
 			return err
 		}
-		pullURL, err := app.proposeUpgrade(ctx, module, app.GitHubToken, reqs)
+		if app.FileIssues {
+			if app.DryRun && len(ups) == 0 {
+				fmt.Println("Would close any issue for", module.Path)
+				continue
+			} else if app.DryRun {
+				fmt.Printf("Issue for %s:\n%s\n\n", module.Path, issueDescription(modules.MainVersions(), module.Path, ups))
+				continue
+			}
+			issueURL, err := app.fileIssue(ctx, remoteOpts, modules.MainVersions(), module, ups)
+			if err != nil && len(ups) == 0 {
+				fmt.Printf("Failed closing issue for %s: %s\n", module.Path, err)
+			} else if err != nil {
+				fmt.Printf("Failed filing issue for %s: %s\n", module.Path, err)
+			} else if len(ups) == 0 && issueURL != "" {
+				fmt.Println("Closed issue at", issueURL)
+			} else if len(ups) > 0 {
+				fmt.Println("Issue at", issueURL)
+			}
+			continue
+		}
+		if app.DryRun {
+			d, err := app.previewUpgrade(root, module, targets(ups))
+			if err != nil {
+				fmt.Printf("Failed upgrading %s: %s\n", module.Path, err)
+			} else if d == "" {
+				fmt.Println("No changes for", module.Path)
+			} else {
+				for _, u := range ups {
+					fmt.Println("Upgrade", u)
+				}
+				fmt.Print(d)
+			}
+			continue
+		}
+		location, isPull, err := app.proposeUpgrade(ctx, remoteOpts, module, ups)
 		if err != nil {
-			// Keep trying other modules (the error may be a missing push permission).
-			fmt.Printf("Failed upgrading %s (no push permission?): %s\n", module.Path, err)
+			// Keep trying other modules.
+			fmt.Printf("Failed upgrading %s: %s\n", module.Path, err)
 			continue
-		} else if pullURL == ""  {
+		} else if location == ""  {
 			fmt.Println("No changes for", module.Path)
 			continue
+		} else if !isPull {
+			fmt.Println("Pushed branch, open a pull request at", location)
+			continue
+		}
+		fmt.Println("Pull request at", location)
+	}
+	return nil
+}
+
+// Applies upgrades to stale requirements of the main modules directly to their go.mod files in the local workspace,
+// then tidies each module. If of is non-empty, only requirements on that module (optionally @version) are upgraded.
+func (app *Rehab) ApplyLocal(root string, of string) error {
+	if app.DryRun {
+		return fmt.Errorf("local upgrades can't be dry run; review them with version control instead")
+	}
+	modules, err := app.fetchModules(root)
+	if err != nil {
+		return err
+	}
+	modGraph, err := app.fetchModGraph(root)
+	if err != nil {
+		return err
+	}
+	stale, _, err := app.findStale(root, modules, modGraph, of)
+	if err != nil {
+		return err
+	}
+	upgrades, err := app.selectUpgrades(modules, stale, false, of, true)
+	if err != nil {
+		return err
+	}
+	upstream, err := parseOf(of)
+	if err != nil {
+		return err
+	}
+	for _, mainModule := range modules.Mains() {
+		var majors []*MajorVersion
+		if app.MajorUpgrade {
+			reqs := moduleRequirements(modules, modGraph, mainModule, map[string]struct{}{})
+			mods := app.selectMajorUpgrades(modules, mainModule.Path, reqs, upstream)
+			if majors, err = app.findMajorVersions(root, mods); err != nil {
+				return err
+			}
+		}
+		if err := app.applyLocal(root, mainModule, upgrades[mainModule.Path], majors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Selects a main module's requirements to move to new major versions, subject to the same configuration as
+// upgrades, and restricted to the upstream module if its path is set. A pinned requirement isn't moved.
+func (app *Rehab) selectMajorUpgrades(modules *db.Modules, mainPath string, reqs []model.ModuleVersion, upstream model.ModuleVersion) []model.ModuleVersion {
+	cfg := app.configuration()
+	var mods []model.ModuleVersion
+	for _, req := range reqs {
+		if upstream.Path != "" && req.Path != upstream.Path {
+			continue
+		}
+		if info, err := modules.ForPath(req.Path); err != nil || info.Replace != nil {
+			continue
+		}
+		if ok, reason := cfg.Propose.Allows(req.Path); !ok {
+			fmt.Printf("Not moving %s requirement on %s to a new major version (%s)\n", mainPath, req, reason)
+			continue
+		}
+		if pin := cfg.PinFor(req.Path); pin != nil {
+			fmt.Printf("Not moving %s requirement on %s to a new major version (%s)\n", mainPath, req, pin)
+			continue
 		}
-		fmt.Println("Pull request at", pullURL)
+		mods = append(mods, req)
+	}
+	return mods
+}
+
+// Applies upgrades, including to new major versions, to a main module's go.mod file and tidies it.
+func (app *Rehab) applyLocal(root string, mainModule *model.ModuleInfo, ups []*upgrade, majors []*MajorVersion) error {
+	reqs := targets(ups)
+	if len(reqs) == 0 && len(majors) == 0 {
+		fmt.Println("No changes for", mainModule.Path)
+		return nil
+	}
+
+	goModPath := mainModule.GoMod
+	if goModPath == "" {
+		goModPath = filepath.Join(root, "go.mod")
+	}
+	goSumPath := filepath.Join(filepath.Dir(goModPath), "go.sum")
+	originalMod, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return err
 	}
+	originalSum, err := ioutil.ReadFile(goSumPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	newMod, err := upgradeGoMod(goModPath, originalMod, reqs)
+	if err != nil {
+		return err
+	}
+	for _, u := range ups {
+		fmt.Println("Upgraded", u)
+	}
+	if len(majors) > 0 {
+		if newMod, err = upgradeGoModMajor(goModPath, newMod, majors); err != nil {
+			return err
+		}
+		changed, err := rewriteMajorImports(filepath.Dir(goModPath), majors)
+		if err != nil {
+			return err
+		}
+		for _, mv := range majors {
+			fmt.Printf("Upgraded %s to %s\n", mv.Module, mv.Latest)
+		}
+		for _, f := range changed {
+			fmt.Println("Rewrote imports in", f)
+		}
+	}
+	if err := ioutil.WriteFile(goModPath, newMod, 0644); err != nil {
+		return err
+	}
+	log.Printf("tidying %s", mainModule.Path)
+	if _, err := fetch.Exec(filepath.Dir(goModPath), "go", "mod", "tidy"); err != nil {
+		return fmt.Errorf("failed tidying %s: %w", mainModule.Path, err)
+	}
+
+	// Report the changes, including any made by tidying.
+	tidyMod, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return err
+	}
+	tidySum, err := ioutil.ReadFile(goSumPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	name := "go.mod"
+	if absRoot, err := filepath.Abs(root); err != nil {
+		return err
+	} else if rel, err := filepath.Rel(absRoot, goModPath); err == nil {
+		name = filepath.ToSlash(rel)
+	}
+	fmt.Print(diff.Unified("a/"+name, "b/"+name, originalMod, tidyMod))
+	added, removed := diff.Count(originalSum, tidySum)
+	fmt.Printf("go.sum: %d lines added, %d removed\n", added, removed)
 	return nil
 }
 
 ///// Private implementation /////
 
+// A proposed upgrade of a stale requirement.
+type upgrade struct {
+	*StaleVersion
+	Version string // The version to upgrade the requirement to
+}
+
+// Classifies the change from the declared version to the upgrade version.
+func (u *upgrade) Bump() string {
+	return ClassifyBump(u.Requirement.Version, u.Version)
+}
+
+func (u *upgrade) String() string {
+	return fmt.Sprintf("%s %s → %s (%s)", u.Requirement.Path, u.Requirement.Version, u.Version, u.Bump())
+}
+
+// A stale requirement excluded from reports by configuration.
+type ignoredVersion struct {
+	*StaleVersion
+	Reason string // Why the requirement is ignored
+}
+
+func (iv *ignoredVersion) String() string {
+	return fmt.Sprintf("%s requires %s (%s)", iv.Consumer, iv.Requirement, iv.Reason)
+}
+
+// Returns the configuration in effect.
+func (app *Rehab) configuration() *config.Config {
+	if app.Config == nil {
+		return config.Default()
+	}
+	return app.Config
+}
+
+// Returns the requirement module versions targeted by upgrades.
+func targets(ups []*upgrade) []model.ModuleVersion {
+	reqs := make([]model.ModuleVersion, len(ups))
+	for i, u := range ups {
+		reqs[i] = model.ModuleVersion{Path: u.Requirement.Path, Version: u.Version}
+	}
+	return reqs
+}
+
+// Finds stale requirements, including those on retracted versions, and applies the replacement policy,
+// import filter and configured rules. Returns the stale requirements to report, and those ignored by configuration.
+// If of names a module@version, requirements on older versions of it are included even where they declare
+// the selected version.
+func (app *Rehab) findStale(root string, modules *db.Modules, modGraph *db.ModGraph, of string) ([]*StaleVersion, []*ignoredVersion, error) {
+	cfg := app.configuration()
+	upstream, err := parseOf(of)
+	if err != nil {
+		return nil, nil, err
+	}
+	stale := FindStaleVersions(modules, modGraph, cfg.Traverse)
+	if upstream.Path != "" {
+		if upstream, err = defaultUpstreamVersion(modules, upstream); err != nil {
+			return nil, nil, err
+		}
+		// Consumers requiring the selected version may still require a version older than that to be pushed.
+		stale = FindOlderRequirements(modules, modGraph, upstream, stale, cfg.Traverse)
+	}
+	versions, err := app.fetchRequiredVersions(root, modules, stale)
+	if err != nil {
+		// Continue without retraction information.
+		_, _ = fmt.Fprintln(os.Stderr, "failed checking retracted versions:", err)
+	} else {
+		stale = FindRetractedVersions(modules, modGraph, versions, stale, cfg.Traverse)
+	}
+	if app.Metrics {
+		MeasureStaleness(stale, modules, versions)
+	}
+	if app.ReplacedPolicy == ReplacedSkip {
+		stale = withoutReplaced(stale)
+	}
+	if app.ImportedOnly {
+		packages, err := app.fetchPackages(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		stale = FilterImported(stale, packages)
+	}
+
+	var reported []*StaleVersion
+	var ignored []*ignoredVersion
+	for _, s := range stale {
+		if ok, reason := cfg.Report.Allows(s.Requirement.Path); !ok {
+			ignored = append(ignored, &ignoredVersion{StaleVersion: s, Reason: reason})
+			continue
+		}
+		s.Pin = cfg.PinFor(s.Requirement.Path)
+		reported = append(reported, s)
+	}
+	return reported, ignored, nil
+}
+
+// Parses the module, optionally @version, to which upgrades are restricted, returning a zero module if of is empty.
+func parseOf(of string) (model.ModuleVersion, error) {
+	var upstream model.ModuleVersion
+	if of != "" {
+		if err := upstream.Parse(of); err != nil {
+			return upstream, fmt.Errorf("bad module %s: %w", of, err)
+		}
+		if upstream.Version != "" && !semver.IsValid(upstream.Version) {
+			return upstream, fmt.Errorf("bad module version %s", upstream.Version)
+		}
+	}
+	return upstream, nil
+}
+
+// Returns an upstream module to push with its version, defaulting to the module's latest version.
+func defaultUpstreamVersion(modules *db.Modules, upstream model.ModuleVersion) (model.ModuleVersion, error) {
+	if upstream.Version != "" {
+		return upstream, nil
+	}
+	info, err := modules.ForPath(upstream.Path)
+	if err != nil {
+		return upstream, fmt.Errorf("no module %s in the build", upstream.Path)
+	}
+	upstream.Version = info.Version
+	if info.Update != nil {
+		upstream.Version = info.Update.Version
+	}
+	return upstream, nil
+}
+
+// Selects upgrades for stale requirements, keyed by consuming module.
+// Upgrades off retracted versions are ordered first.
+// See Propose for the meaning of all and of.
+// Requirements replaced by a local directory are upgraded only if local is set (the upgrade won't be pushed).
+func (app *Rehab) selectUpgrades(modules *db.Modules, stale []*StaleVersion, all bool, of string, local bool) (map[string][]*upgrade, error) {
+	cfg := app.configuration()
+	if err := checkMaxBump(app.MaxBump); err != nil {
+		return nil, err
+	}
+
+	upstream, err := parseOf(of)
+	if err != nil {
+		return nil, err
+	}
+
+	// Proposed requirement upgrades keyed by consuming module
+	upgrades := map[string][]*upgrade{}
+	for _, s := range stale {
+		upgradeTo := s.HighestVersion
+		if app.MinimumUpgrade {
+			upgradeTo = s.SelectedVersion
+		}
+		if upstream.Path != "" {
+			// Push a release of the upstream module to all its consumers.
+			if s.Requirement.Path != upstream.Path {
+				continue
+			}
+			if upstream.Version != "" {
+				if semver.Compare(s.Requirement.Version, upstream.Version) >= 0 {
+					continue
+				}
+				upgradeTo = upstream.Version
+			}
+		} else if !modules.IsMain(s.Consumer.Path) && !all {
+			continue
+		}
+		if s.Replacement != nil {
+			if app.ReplacedPolicy != ReplacedUpgrade {
+				log.Printf("not upgrading %s requirement on replaced %s", s.Consumer, s.Requirement)
+				continue
+			}
+			if s.ReplacedLocally() && !local {
+				fmt.Printf("Not upgrading %s requirement on %s, replaced by local directory %s\n",
+					s.Consumer, s.Requirement, s.Replacement.Path)
+				continue
+			}
+		}
+		if upgradeTo == s.Requirement.Version {
+			continue // e.g. a retracted version with no later release
+		}
+		if ok, reason := cfg.Propose.Allows(s.Requirement.Path); !ok {
+			fmt.Printf("Not upgrading %s requirement on %s (%s)\n", s.Consumer, s.Requirement, reason)
+			continue
+		}
+		bumpLimited := app.MaxBump != "" && upstream.Version == ""
+		if bumpLimited || (s.Pin != nil && !s.Pin.Allows(upgradeTo)) {
+			// Restrict the upgrade to the highest available version within the maximum bump and pin.
+			var available []string
+			if info, err := modules.ForPath(s.Requirement.Path); err == nil {
+				available = info.Versions
+			}
+			from := s.Requirement.Version
+			allowed := highestAllowed(available, from, upgradeTo, func(v string) bool {
+				return (!bumpLimited || bumpAllowed(from, v, app.MaxBump)) && (s.Pin == nil || s.Pin.Allows(v))
+			})
+			if allowed == "" && s.Pin != nil && !s.Pin.Allows(upgradeTo) {
+				fmt.Printf("Not upgrading %s requirement on %s (%s)\n", s.Consumer, s.Requirement, s.Pin)
+				continue
+			} else if allowed == "" {
+				log.Printf("not upgrading %s requirement on %s to %s, a %s bump", s.Consumer, s.Requirement,
+					upgradeTo, ClassifyBump(from, upgradeTo))
+				continue
+			}
+			upgradeTo = allowed
+		}
+		upgrades[s.Consumer.Path] = append(upgrades[s.Consumer.Path], &upgrade{
+			StaleVersion: s,
+			Version:      upgradeTo,
+		})
+	}
+	if upstream.Path != "" && len(upgrades) == 0 {
+		fmt.Println("No stale requirements on", upstream)
+	}
+	for _, ups := range upgrades {
+		sort.SliceStable(ups, func(i, j int) bool {
+			return ups[i].Retracted != nil && ups[j].Retracted == nil
+		})
+	}
+
+	return upgrades, nil
+}
+
+// Returns the main modules' direct requirements, at their selected versions.
+func directRequirements(modules *db.Modules, modGraph *db.ModGraph) []model.ModuleVersion {
+	var reqs []model.ModuleVersion
+	seen := map[string]struct{}{}
+	for _, main := range modules.Mains() {
+		reqs = append(reqs, moduleRequirements(modules, modGraph, main, seen)...)
+	}
+	return reqs
+}
+
+// Returns a module's direct requirements, at their selected versions, omitting main modules and those
+// already seen. Adds the requirements to those seen.
+func moduleRequirements(modules *db.Modules, modGraph *db.ModGraph, module *model.ModuleInfo, seen map[string]struct{}) []model.ModuleVersion {
+	var reqs []model.ModuleVersion
+	for _, req := range modGraph.UpstreamOf(module.Path, module.Version) {
+		if _, ok := seen[req.Upstream.Path]; ok || req.Downstream.Version != module.Version {
+			continue
+		}
+		if info, err := modules.ForPath(req.Upstream.Path); err == nil && !info.Main {
+			seen[info.Path] = struct{}{}
+			reqs = append(reqs, model.ModuleVersion{Path: info.Path, Version: info.Version})
+		}
+	}
+	return reqs
+}
+
+// Filters out stale requirements on replaced modules.
+func withoutReplaced(stale []*StaleVersion) []*StaleVersion {
+	var result []*StaleVersion
+	for _, s := range stale {
+		if s.Replacement == nil {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
 func (app *Rehab) fetchModules(root string) (*db.Modules, error) {
-	mods, err := fetch.ListModules(root)
+	loadVersions := app.Metrics || app.MaxBump != "" || len(app.configuration().Pins) > 0
+	mods, err := fetch.ListModules(root, loadVersions)
 	if err != nil {
 		return nil, fmt.Errorf("error listing modules: %w", err)
 	}
@@ -109,74 +662,219 @@ func (app *Rehab) fetchModGraph(root string) (*db.ModGraph, error) {
 	return db.NewModGraph(modDeps), nil
 }
 
+// Fetches information about the module versions declared by stale requirements. The database also holds the
+// module versions in the build list, whose information (including retractions) was listed with the modules.
+// Information about other requirements, which declare the selected versions, is then already to hand, so
+// only the versions of stale requirements are looked up.
+func (app *Rehab) fetchRequiredVersions(root string, modules *db.Modules, stale []*StaleVersion) (*db.ModuleVersions, error) {
+	infos := append([]*model.ModuleInfo{}, modules.All()...)
+	known := map[model.ModuleVersion]struct{}{}
+	for _, info := range infos {
+		known[model.ModuleVersion{Path: info.Path, Version: info.Version}] = struct{}{}
+	}
+	var versions []model.ModuleVersion
+	for _, s := range stale {
+		if _, ok := known[s.Requirement]; !ok {
+			known[s.Requirement] = struct{}{}
+			versions = append(versions, s.Requirement)
+		}
+	}
+	if len(versions) > 0 {
+		fetched, err := fetch.ListModuleVersions(root, versions)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, fetched...)
+	}
+	return db.NewModuleVersions(infos), nil
+}
+
 func (app *Rehab) fetchPackages(root string) ([]*model.PackageInfo, error) {
 	packages, err := fetch.ListPackages(root)
 	if err != nil {
-		return nil, fmt.Errorf("error listing dependencies: %w", err)
+		return nil, fmt.Errorf("error listing packages: %w", err)
 	}
 	return packages, nil
 }
 
-// Returns URL to a PR or comparison, or "" if no changes made.
-func (app *Rehab) proposeUpgrade(ctx context.Context, module *model.ModuleInfo, token string, reqs []model.ModuleVersion) (url string, err error) {
+// Returns the URL of a pull request and true or, if no pull request was made, the location of the pushed branch
+// to compare and false. Returns "" if no changes were made.
+func (app *Rehab) proposeUpgrade(ctx context.Context, opts remote.Options, module *model.ModuleInfo, ups []*upgrade) (location string, isPull bool, err error) {
 	log.Printf("upgrading requirements for %s", module.Path)
-	repo, err := remote.Open(ctx, module.Path, token)
+	reqs := targets(ups)
+	repo, err := remote.Open(ctx, module.Path, opts)
+	if err != nil {
+		return "", false, err
+	}
+	defer repo.Close()
 
-	// FIXME Find go.mod file when it's not in the root, like for nested modules
-	commitSHA, err := repo.EditFile(ctx, "go.mod", func(original []byte) ([]byte, error) {
-		modFile, err := modfile.Parse("go.mod", original, nil)
+	modName, err := repo.FindGoMod(ctx, module.Path)
+	if err != nil {
+		return "", false, err
+	}
+	sumName := path.Join(path.Dir(modName), "go.sum")
+	commitSHA, err := repo.EditFiles(ctx, []string{modName, sumName}, func(files map[string][]byte) (map[string][]byte, error) {
+		original := files[modName]
+		newContent, err := upgradeGoMod(modName, original, reqs)
 		if err != nil {
-			return nil, fmt.Errorf("failed parsing go.mod: %w", err)
+			return nil, err
 		}
-
-		// Replace go.mod file lines
-		modified := false
-		for _, req := range reqs {
-			err = modFile.AddRequire(req.Path, req.Version) // Updates requirement in-place, preserving comments.
-			if err != nil {
-				log.Printf("failed to add requirement %s: %w", req, err)
-				continue
-			}
-			modified = true
+		if bytes.Equal(original, newContent) {
+			return nil, nil
 		}
-		if !modified {
-			return original, nil
-		}
-
-		newContent, err := modFile.Format()
+		// Checksums for the new requirements must be committed alongside them for the module to build.
+		newSums, err := fetch.ResolveSums(newContent, files[sumName])
 		if err != nil {
-			return nil, fmt.Errorf("failed to format new go.mod file: %w", err)
+			return nil, fmt.Errorf("failed to resolve new go.sum file: %w", err)
 		}
-		return newContent, nil
+		return map[string][]byte{modName: newContent, sumName: newSums}, nil
 	})
 	if err != nil {
-		return "", err
+		return "", false, err
 	} else if commitSHA == "" {
-		return "", nil
+		return "", false, nil
 	}
 
-	// Create a branch pointing at the commit
-	refName, err := repo.MakeBranch(ctx, commitSHA, app.BranchPrefix+"upgrade")
+	// Create a branch pointing at the commit, naming the module if it's not at the repository root.
+	title := "Update module requirements"
+	for _, u := range ups {
+		if u.Retracted != nil {
+			title = "Update retracted module requirements"
+			break
+		}
+	}
+	if repo.Dir() != "" {
+		title += " for " + module.Path
+	}
+	refName, err := repo.MakeBranch(ctx, commitSHA, app.BranchPrefix+upgradeBranchName(repo.Dir(), reqs))
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 
-	title := "Update module requirements"
-	body := "Upgrades to the latest version of requirements.\n\n" +
-		"This is an automated PR created by Rehab."
+	body := upgradeDescription(ups)
 	if app.MakePullRequests {
 		pullURL, err := repo.MakePull(ctx, refName, title, body)
 		if err != nil {
-			return "", err
+			return "", false, err
+		} else if pullURL != "" {
+			return pullURL, true, nil
 		}
-		return pullURL, nil
-	} else {
-		compareURL, err := repo.CompareBranch(refName, title, body)
+		// The host has no pull requests.
+	}
+	compareURL, err := repo.CompareBranch(refName, title, body)
+	if err != nil {
+		return "", false, err
+	}
+	return compareURL, false, nil
+}
+
+// Describes a set of upgrades for a pull request.
+func upgradeDescription(ups []*upgrade) string {
+	var b strings.Builder
+	b.WriteString("Upgrades module requirements:\n")
+	for _, u := range ups {
+		fmt.Fprintf(&b, "- %s", u)
+		if u.Retracted != nil {
+			fmt.Fprintf(&b, " (%s is retracted: %s)", u.Requirement.Version, retractionRationale(u.Retracted))
+		}
+		if u.Deprecated != "" {
+			fmt.Fprintf(&b, " (module is deprecated: %s)", u.Deprecated)
+		}
+		if u.Pin != nil {
+			fmt.Fprintf(&b, " (%s)", u.Pin)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nThis is an automated PR created by Rehab.")
+	return b.String()
+}
+
+// Computes the go.mod changes that proposing an upgrade would make, without any remote access.
+// The upgrade is applied to the go.mod of the module version in the build list, rather than the module's
+// latest source. Returns a unified diff of the module's go.mod, or "" if no changes would be made.
+func (app *Rehab) previewUpgrade(root string, module *model.ModuleInfo, reqs []model.ModuleVersion) (string, error) {
+	goModPath := module.GoMod
+	if goModPath == "" {
+		infos, err := fetch.ListModuleVersions(root, []model.ModuleVersion{{Path: module.Path, Version: module.Version}})
 		if err != nil {
 			return "", err
 		}
-		return compareURL, nil
+		goModPath = infos[0].GoMod
+	}
+	original, err := ioutil.ReadFile(goModPath)
+	if err != nil {
+		return "", fmt.Errorf("failed reading go.mod for %s: %w", module.Path, err)
+	}
+	newContent, err := upgradeGoMod(goModPath, original, reqs)
+	if err != nil {
+		return "", err
+	}
+	name := path.Join(module.Path, "go.mod")
+	return diff.Unified("a/"+name, "b/"+name, original, newContent), nil
+}
+
+// Updates requirements in go.mod file content, returning the new content.
+func upgradeGoMod(name string, original []byte, reqs []model.ModuleVersion) ([]byte, error) {
+	modFile, err := modfile.Parse(name, original, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing go.mod: %w", err)
+	}
+
+	// Replace go.mod file lines
+	modified := false
+	for _, req := range reqs {
+		err = modFile.AddRequire(req.Path, req.Version) // Updates requirement in-place, preserving comments.
+		if err != nil {
+			log.Printf("failed to add requirement %s: %s", req, err)
+			continue
+		}
+		modified = true
+	}
+	if !modified {
+		return original, nil
+	}
+
+	newContent, err := modFile.Format()
+	if err != nil {
+		return nil, fmt.Errorf("failed to format new go.mod file: %w", err)
+	}
+	return newContent, nil
+}
+
+// Builds a branch name identifying a set of requirement upgrades to a module, so that proposing the same set again
+// re-uses the branch while a different set gets a branch of its own.
+// The module is identified by its directory within the repository (empty for the root), and the
+// requirements by a hash of the set, after a summary for a single requirement. The summary names only the last
+// elements of the module path, so the hash distinguishes modules with the same name.
+func upgradeBranchName(moduleDir string, reqs []model.ModuleVersion) string {
+	name := "upgrade"
+	if moduleDir != "" {
+		name += "-" + strings.ReplaceAll(moduleDir, "/", "-")
+	}
+	if len(reqs) == 1 {
+		name += "-" + shortModuleName(reqs[0].Path) + "-" + reqs[0].Version
+	}
+	set := make([]string, len(reqs))
+	for i, req := range reqs {
+		set[i] = req.String()
+	}
+	sort.Strings(set)
+	hash := sha256.Sum256([]byte(strings.Join(set, "\n")))
+	return name + "-" + hex.EncodeToString(hash[:4])
+}
+
+// Returns the last element of a module path, including any major version suffix, e.g. "bar-v2" for
+// github.com/foo/bar/v2.
+func shortModuleName(modPath string) string {
+	prefix, major, ok := module.SplitPathVersion(modPath)
+	if !ok {
+		return path.Base(modPath)
+	}
+	name := path.Base(prefix)
+	if major != "" {
+		name += "-" + strings.Trim(major, "/.")
 	}
+	return name
 }
 
 //func dumpModules(modules *db.Modules) {
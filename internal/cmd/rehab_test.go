@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/anorth/rehab/internal/config"
+	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/pkg/model"
+)
+
+func TestShortModuleName(t *testing.T) {
+	for modPath, expected := range map[string]string{
+		"github.com/foo/bar":    "bar",
+		"github.com/foo/bar/v2": "bar-v2",
+		"gopkg.in/yaml.v3":      "yaml-v3",
+		"example.com/a/sub":     "sub",
+	} {
+		if actual := shortModuleName(modPath); actual != expected {
+			t.Errorf("%s: %q, expected %q", modPath, actual, expected)
+		}
+	}
+}
+
+func TestUpgradeBranchName(t *testing.T) {
+	reqs := func(t *testing.T, mvs ...string) []model.ModuleVersion {
+		var result []model.ModuleVersion
+		for _, s := range mvs {
+			result = append(result, mv(t, s))
+		}
+		return result
+	}
+	for _, tc := range []struct {
+		name    string
+		dir     string
+		reqs    []string
+		pattern string
+	}{
+		{"single", "", []string{"github.com/a/log@v1.0.0"}, `^upgrade-log-v1\.0\.0-[0-9a-f]{8}$`},
+		{"major version path", "", []string{"github.com/a/log/v2@v2.1.0"}, `^upgrade-log-v2-v2\.1\.0-[0-9a-f]{8}$`},
+		{"subdirectory", "sub/dir", []string{"github.com/a/log@v1.0.0"}, `^upgrade-sub-dir-log-v1\.0\.0-[0-9a-f]{8}$`},
+		{"set", "", []string{"github.com/a/log@v1.0.0", "github.com/b/x@v1.2.0"}, `^upgrade-[0-9a-f]{8}$`},
+	} {
+		name := upgradeBranchName(tc.dir, reqs(t, tc.reqs...))
+		if !regexp.MustCompile(tc.pattern).MatchString(name) {
+			t.Errorf("%s: branch %q, expected to match %s", tc.name, name, tc.pattern)
+		}
+	}
+
+	// The same set, in any order, gets the same name, and different sets get different names.
+	names := map[string]string{}
+	for _, set := range [][]string{
+		{"github.com/a/log@v1.0.0"},
+		{"github.com/b/log@v1.0.0"},
+		{"github.com/a/log@v1.0.1"},
+		{"github.com/a/log/v2@v2.0.0"},
+		{"github.com/a/log@v1.0.0", "github.com/b/x@v1.2.0"},
+		{"github.com/a/log@v1.0.0", "github.com/b/x@v1.3.0"},
+		{"github.com/a/log@v1.0.0", "github.com/b/x@v1.2.0", "github.com/c/y@v0.1.0"},
+	} {
+		name := upgradeBranchName("", reqs(t, set...))
+		if other, ok := names[name]; ok {
+			t.Errorf("sets %v and %s share branch %s", set, other, name)
+		}
+		names[name] = fmt.Sprint(set)
+	}
+	forward := upgradeBranchName("sub", reqs(t, "github.com/a/log@v1.0.0", "github.com/b/x@v1.2.0"))
+	backward := upgradeBranchName("sub", reqs(t, "github.com/b/x@v1.2.0", "github.com/a/log@v1.0.0"))
+	if forward != backward {
+		t.Errorf("same set named %s and %s", forward, backward)
+	}
+	if forward == upgradeBranchName("", reqs(t, "github.com/a/log@v1.0.0", "github.com/b/x@v1.2.0")) {
+		t.Errorf("same set in different directories named %s", forward)
+	}
+}
+
+func TestSelectMajorUpgrades(t *testing.T) {
+	modules := db.NewModules([]*model.ModuleInfo{
+		{Path: "example.com/main", Main: true},
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/b", Version: "v1.0.0"},
+		{Path: "example.com/c", Version: "v1.0.0"},
+		{Path: "example.com/pinned", Version: "v1.0.0"},
+		{Path: "example.com/replaced", Version: "v1.0.0", Replace: &model.ModuleInfo{Path: "example.com/fork", Version: "v1.0.1"}},
+	})
+	var reqs []model.ModuleVersion
+	for _, info := range modules.All() {
+		if !info.Main {
+			reqs = append(reqs, model.ModuleVersion{Path: info.Path, Version: info.Version})
+		}
+	}
+	app := &Rehab{Config: &config.Config{
+		Propose: config.Rules{Exclude: []config.Rule{{Module: "example.com/b", Reason: "frozen"}}},
+		Pins:    []config.Pin{{Module: "example.com/pinned", Version: "v1"}},
+	}}
+	for of, expected := range map[string][]string{
+		"":              {"example.com/a@v1.0.0", "example.com/c@v1.0.0"},
+		"example.com/c": {"example.com/c@v1.0.0"},
+		"example.com/b": nil,
+	} {
+		upstream, err := parseOf(of)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, m := range app.selectMajorUpgrades(modules, "example.com/main", reqs, upstream) {
+			actual = append(actual, m.String())
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("of %q: selected %v, expected %v", of, actual, expected)
+		}
+	}
+}
+
+func TestDefaultUpstreamVersion(t *testing.T) {
+	modules := db.NewModules([]*model.ModuleInfo{
+		{Path: "example.com/main", Main: true},
+		{Path: "example.com/a", Version: "v1.0.0", Update: &model.ModuleInfo{Path: "example.com/a", Version: "v1.2.0"}},
+		{Path: "example.com/b", Version: "v1.1.0"},
+	})
+	for of, expected := range map[string]string{
+		"example.com/a":        "example.com/a@v1.2.0",
+		"example.com/a@v1.1.0": "example.com/a@v1.1.0",
+		"example.com/b":        "example.com/b@v1.1.0",
+	} {
+		upstream, err := defaultUpstreamVersion(modules, mv(t, of))
+		if err != nil || upstream.String() != expected {
+			t.Errorf("%s: %s (%v), expected %s", of, upstream, err, expected)
+		}
+	}
+	if _, err := defaultUpstreamVersion(modules, mv(t, "example.com/missing")); err == nil {
+		t.Errorf("expected error for a module not in the build")
+	}
+}
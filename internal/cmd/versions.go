@@ -2,20 +2,40 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strings"
 
+	"github.com/anorth/rehab/internal/config"
 	"github.com/anorth/rehab/internal/db"
 	"github.com/anorth/rehab/pkg/model"
+	"golang.org/x/mod/semver"
 )
 
 type StaleVersion struct {
-	Consumer        model.ModuleVersion // The module version requiring an old upstream dependency
-	Requirement     model.ModuleVersion // The upstream module required and declared version
-	SelectedVersion string              // The requirement version selected by MVS
-	SelectedReason  model.ModuleVersion // A (transitively) required module that declares the MVS-selected version requirement
-	TransitiveStale bool                // True if the requirement has transitively stale requirements and is not a latest version
-	HighestVersion  string              // The highest available version of the requirement
+	Consumer          model.ModuleVersion // The module version requiring an old upstream dependency
+	Requirement       model.ModuleVersion // The upstream module required and declared version
+	SelectedVersion   string              // The requirement version selected by MVS
+	SelectedReason    model.ModuleVersion // A (transitively) required module that declares the MVS-selected version requirement
+	TransitiveStale   bool                // True if the requirement has transitively stale requirements and is not a latest version
+	HighestVersion    string              // The highest available version of the requirement
+	Replacement       *model.ModuleInfo   // The module replacing the requirement in the main module's build, if any
+	Retracted         []string            // Rationale for retraction of the declared requirement version, if retracted
+	SelectedRetracted []string            // Rationale for retraction of the selected version, if retracted
+	Deprecated        string              // Deprecation message of the requirement's module, if deprecated
+	Links             []PackageLink       // Package imports linking the consumer to the requirement, if known
+	Metrics           *Staleness          // How far the requirement lags the highest version, if measured
+	Pin               *config.Pin         // Configured upper bound on upgrades of the requirement, if any
+}
+
+// Checks whether the requirement is replaced by a directory in the local filesystem.
+func (sv *StaleVersion) ReplacedLocally() bool {
+	return sv.Replacement != nil && sv.Replacement.Version == ""
+}
+
+// Classifies the change from the declared version to the highest version.
+func (sv *StaleVersion) Bump() string {
+	return ClassifyBump(sv.Requirement.Version, sv.HighestVersion)
 }
 
 func (sv *StaleVersion) String() string {
@@ -26,20 +46,47 @@ func (sv *StaleVersion) String() string {
 	if sv.TransitiveStale {
 		via = via + " (has stale transitive requirements)"
 	}
+	if sv.Retracted != nil {
+		via = via + fmt.Sprintf(" (%s retracted: %s)", sv.Requirement.Version, retractionRationale(sv.Retracted))
+	}
+	if sv.SelectedRetracted != nil && sv.SelectedVersion != sv.Requirement.Version {
+		via = via + fmt.Sprintf(" (%s retracted: %s)", sv.SelectedVersion, retractionRationale(sv.SelectedRetracted))
+	}
+	if sv.Deprecated != "" {
+		via = via + " (deprecated)"
+	}
+	if sv.Links != nil {
+		via = via + fmt.Sprintf(" (%s)", summariseLinks(sv.Links))
+	}
+	if sv.Metrics != nil {
+		via = via + fmt.Sprintf(" (%s)", sv.Metrics)
+	}
+	if sv.Pin != nil {
+		via = via + fmt.Sprintf(" (%s)", sv.Pin)
+	}
+	if sv.Replacement != nil {
+		via = via + fmt.Sprintf(" (replaced by %s)",
+			model.ModuleVersion{Path: sv.Replacement.Path, Version: sv.Replacement.Version})
+	}
 
-	return fmt.Sprintf("%s requires %s, builds with %s%s (highest %s)",
-		sv.Consumer, sv.Requirement, sv.SelectedVersion, via, sv.HighestVersion)
+	return fmt.Sprintf("%s requires %s, builds with %s%s (highest %s, %s)",
+		sv.Consumer, sv.Requirement, sv.SelectedVersion, via, sv.HighestVersion, sv.Bump())
 }
 
 // Finds imports in a dependency tree based at some root module where a declared dependency module version
 // does not match the module version chosen by MVS when building the root module.
 // This situation means that the tests for the consuming module run with a different version of the
 // dependency than that actually used in production.
-func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersion {
+// The requirements of modules not matched by the traversal rules are not examined, except for the main modules.
+func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph, traverse config.Rules) []*StaleVersion {
 	var found []*StaleVersion
-	q := []model.ModuleVersion{{modules.Main().Path, modules.Main().Version}}
+	q := modules.MainVersions()
+	buildList := modGraph.BuildList(q...)
 	// Records the modules in the graph which have been traversed already.
-	modulesSeen := map[string]struct{}{q[0].Path: {}}
+	modulesSeen := map[string]struct{}{}
+	for _, main := range q {
+		modulesSeen[main.Path] = struct{}{}
+	}
 	// Records the stale relationships already recorded.
 	type staleversionkey struct {
 		consumer, requirement string
@@ -60,11 +107,20 @@ func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersi
 			if _, ok := edgesSeen[key]; ok {
 				continue
 			}
-			upstreamSelected, reason, err := modGraph.SelectedVersion(req.Upstream.Path)
-			if err != nil {
-				_, _ = fmt.Fprintln(os.Stderr, "failed checking highest version of", req.Upstream.Path, err)
+			if modules.IsMain(req.Upstream.Path) {
+				// A requirement cycle back to a main module, which is unversioned in the build.
 				continue
 			}
+			selection, ok := buildList.Selected(req.Upstream.Path)
+			if !ok {
+				// Not a module in the build list, e.g. the "go" version pseudo-module.
+				continue
+			}
+			upstreamSelected := selection.Module.Version
+			var reason model.ModuleVersion // Zero for a selection with no requirers, such as a main module
+			if len(selection.RequiredBy) > 0 {
+				reason = selection.RequiredBy[0]
+			}
 			upstreamInfo, err := modules.ForPath(req.Upstream.Path)
 			if err != nil {
 				_, _ = fmt.Fprintln(os.Stderr, "failed loading module of", req.Upstream.Path, err)
@@ -88,6 +144,8 @@ func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersi
 					SelectedReason:  reason,
 					TransitiveStale: false,
 					HighestVersion:  upstreamLatest,
+					Replacement:     upstreamInfo.Replace,
+					Deprecated:      upstreamInfo.Deprecated,
 				})
 				edgesSeen[key] = struct{}{}
 
@@ -100,8 +158,12 @@ func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersi
 				// Trace through deeper in the requirement graph only for the version of the upstream
 				// that is the one selected by MVS.
 				_, seen := modulesSeen[req.Upstream.Path]
-				if !seen && (! strings.HasPrefix(req.Upstream.Path, "golang.org/")) { // Replace with whitelist?
-					q = append(q, req.Upstream)
+				if !seen {
+					if ok, reason := traverse.Allows(req.Upstream.Path); ok {
+						q = append(q, req.Upstream)
+					} else {
+						log.Printf("not traversing requirements of %s: %s", req.Upstream.Path, reason)
+					}
 					modulesSeen[req.Upstream.Path] = struct{}{}
 				}
 			}
@@ -122,6 +184,8 @@ func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersi
 					SelectedReason:  req.Downstream,
 					TransitiveStale: true,
 					HighestVersion:  downstreamLatest.Version,
+					Replacement:     downstreamInfo.Replace,
+					Deprecated:      downstreamInfo.Deprecated,
 				})
 				edgesSeen[key] = struct{}{}
 			}
@@ -129,3 +193,149 @@ func FindStaleVersions(modules *db.Modules, modGraph *db.ModGraph) []*StaleVersi
 	}
 	return found
 }
+
+// Finds requirements of module versions in the build list on module versions that have been retracted.
+// The stale versions already found for such requirements are marked as retracted, and the other requirements
+// are appended as further stale versions, even if they declare the selected and latest version.
+// The versions database provides retraction information for required module versions, which need include only
+// the build list and the declared versions of stale requirements; requirements on other versions aren't reported.
+// As for FindStaleVersions, the requirements of modules not matched by the traversal rules are not examined.
+func FindRetractedVersions(modules *db.Modules, modGraph *db.ModGraph, versions *db.ModuleVersions,
+	stale []*StaleVersion, traverse config.Rules) []*StaleVersion {
+	type edgekey struct {
+		consumer, requirement model.ModuleVersion
+	}
+	found := map[edgekey]*StaleVersion{}
+	for _, s := range stale {
+		found[edgekey{s.Consumer, s.Requirement}] = s
+	}
+	retraction := func(mv model.ModuleVersion) []string {
+		if info, ok := versions.ForVersion(mv); ok && info.Error == nil {
+			return info.Retracted
+		}
+		return nil
+	}
+
+	buildList := modGraph.BuildList(modules.MainVersions()...)
+	for _, consumer := range buildList.All() {
+		if ok, _ := traverse.Allows(consumer.Module.Path); !ok && !modules.IsMain(consumer.Module.Path) {
+			continue
+		}
+		for _, req := range modGraph.UpstreamOf(consumer.Module.Path, consumer.Module.Version) {
+			if req.Downstream != consumer.Module || modules.IsMain(req.Upstream.Path) {
+				continue
+			}
+			selection, ok := buildList.Selected(req.Upstream.Path)
+			if !ok {
+				continue
+			}
+			retracted := retraction(req.Upstream)
+			selectedRetracted := retraction(selection.Module)
+			if retracted == nil && selectedRetracted == nil {
+				continue
+			}
+			if s, ok := found[edgekey{req.Downstream, req.Upstream}]; ok {
+				s.Retracted, s.SelectedRetracted = retracted, selectedRetracted
+				continue
+			}
+			upstreamInfo, err := modules.ForPath(req.Upstream.Path)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "failed loading module of", req.Upstream.Path, err)
+				continue
+			}
+			upstreamLatest := upstreamInfo.Version
+			if upstreamInfo.Update != nil {
+				upstreamLatest = upstreamInfo.Update.Version
+			}
+			var reason model.ModuleVersion
+			if len(selection.RequiredBy) > 0 {
+				reason = selection.RequiredBy[0]
+			}
+			s := &StaleVersion{
+				Consumer:          req.Downstream,
+				Requirement:       req.Upstream,
+				SelectedVersion:   selection.Module.Version,
+				SelectedReason:    reason,
+				TransitiveStale:   false,
+				HighestVersion:    upstreamLatest,
+				Replacement:       upstreamInfo.Replace,
+				Retracted:         retracted,
+				SelectedRetracted: selectedRetracted,
+				Deprecated:        upstreamInfo.Deprecated,
+			}
+			found[edgekey{req.Downstream, req.Upstream}] = s
+			stale = append(stale, s)
+		}
+	}
+	return stale
+}
+
+// Finds requirements of module versions in the build list on a module older than a version, appending those
+// not already among the stale versions, even if they declare the selected version.
+// This finds all the consumers to which a new release of the module can be pushed.
+// As for FindStaleVersions, the requirements of modules not matched by the traversal rules are not examined.
+func FindOlderRequirements(modules *db.Modules, modGraph *db.ModGraph, upstream model.ModuleVersion,
+	stale []*StaleVersion, traverse config.Rules) []*StaleVersion {
+	type edgekey struct {
+		consumer, requirement model.ModuleVersion
+	}
+	found := map[edgekey]struct{}{}
+	for _, s := range stale {
+		found[edgekey{s.Consumer, s.Requirement}] = struct{}{}
+	}
+	if modules.IsMain(upstream.Path) {
+		return stale
+	}
+	upstreamInfo, err := modules.ForPath(upstream.Path)
+	if err != nil {
+		return stale // Not in the build list
+	}
+	upstreamLatest := upstreamInfo.Version
+	if upstreamInfo.Update != nil {
+		upstreamLatest = upstreamInfo.Update.Version
+	}
+
+	buildList := modGraph.BuildList(modules.MainVersions()...)
+	selection, ok := buildList.Selected(upstream.Path)
+	if !ok {
+		return stale
+	}
+	var reason model.ModuleVersion
+	if len(selection.RequiredBy) > 0 {
+		reason = selection.RequiredBy[0]
+	}
+	for _, req := range modGraph.DownstreamOf(upstream.Path, "") {
+		if semver.Compare(req.Upstream.Version, upstream.Version) >= 0 {
+			continue
+		}
+		if _, ok := found[edgekey{req.Downstream, req.Upstream}]; ok {
+			continue
+		}
+		// Only the selected version of a consumer is in the build.
+		if consumer, ok := buildList.Selected(req.Downstream.Path); !ok || consumer.Module != req.Downstream {
+			continue
+		}
+		if ok, _ := traverse.Allows(req.Downstream.Path); !ok && !modules.IsMain(req.Downstream.Path) {
+			continue
+		}
+		found[edgekey{req.Downstream, req.Upstream}] = struct{}{}
+		stale = append(stale, &StaleVersion{
+			Consumer:        req.Downstream,
+			Requirement:     req.Upstream,
+			SelectedVersion: selection.Module.Version,
+			SelectedReason:  reason,
+			TransitiveStale: false,
+			HighestVersion:  upstreamLatest,
+			Replacement:     upstreamInfo.Replace,
+			Deprecated:      upstreamInfo.Deprecated,
+		})
+	}
+	return stale
+}
+
+func retractionRationale(rationale []string) string {
+	if len(rationale) == 0 || (len(rationale) == 1 && rationale[0] == "") {
+		return "no rationale given"
+	}
+	return strings.Join(rationale, "; ")
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/anorth/rehab/internal/config"
+	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Builds a module graph from lines in the format of `go mod graph`, and a module database of the modules selected
+// from it, with the first line's consumer as the main module. Latest versions may be given as path@version.
+func parseBuild(t testing.TB, lines []string, latest ...string) (*db.Modules, *db.ModGraph) {
+	var rels []model.ModuleRelationship
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("bad graph line %q", line)
+		}
+		var rel model.ModuleRelationship
+		if err := rel.Downstream.Parse(fields[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := rel.Upstream.Parse(fields[1]); err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, rel)
+	}
+	return buildModules(t, db.NewModGraph(rels), rels[0].Downstream, latest...)
+}
+
+func buildModules(t testing.TB, g *db.ModGraph, main model.ModuleVersion, latest ...string) (*db.Modules, *db.ModGraph) {
+	updates := map[string]string{}
+	for _, l := range latest {
+		var mv model.ModuleVersion
+		if err := mv.Parse(l); err != nil {
+			t.Fatal(err)
+		}
+		updates[mv.Path] = mv.Version
+	}
+	var infos []*model.ModuleInfo
+	for _, sel := range g.BuildList(main).All() {
+		info := &model.ModuleInfo{Path: sel.Module.Path, Version: sel.Module.Version, Main: sel.Module == main}
+		if u, ok := updates[info.Path]; ok {
+			info.Update = &model.ModuleInfo{Path: info.Path, Version: u}
+		}
+		infos = append(infos, info)
+	}
+	return db.NewModules(infos), g
+}
+
+func staleStrings(stale []*StaleVersion) []string {
+	var result []string
+	for _, s := range stale {
+		result = append(result, s.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestFindStaleVersions(t *testing.T) {
+	modules, g := parseBuild(t, []string{
+		"a b@v1.0.0",
+		"a c@v1.0.0",
+		"c@v1.0.0 b@v1.2.0",
+		"b@v1.0.0 d@v1.0.0",
+		"b@v1.2.0 d@v1.1.0",
+	}, "b@v1.3.0")
+	actual := staleStrings(FindStaleVersions(modules, g, config.Rules{}))
+	expected := []string{
+		"a requires b@v1.0.0, builds with v1.2.0 via c@v1.0.0 (highest v1.3.0, minor)",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("stale:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestFindStaleVersionsCycleToMain(t *testing.T) {
+	// b requires an old release of the main module a, which stays selected with no requirers.
+	modules, g := parseBuild(t, []string{
+		"a b@v1.0.0",
+		"b@v1.0.0 a@v0.1.0",
+		"a@v0.1.0 c@v1.0.0",
+		"b@v1.0.0 c@v1.1.0",
+	})
+	for _, s := range FindStaleVersions(modules, g, config.Rules{}) {
+		if s.Requirement.Path == "a" {
+			t.Errorf("reported requirement on main module: %s", s)
+		}
+	}
+	retracted := FindRetractedVersions(modules, g, db.NewModuleVersions(nil), nil, config.Rules{})
+	if len(retracted) != 0 {
+		t.Errorf("unexpected retractions %v", staleStrings(retracted))
+	}
+}
+
+func TestFindRetractedVersions(t *testing.T) {
+	modules, g := parseBuild(t, []string{
+		"a b@v1.0.0",
+		"a c@v1.0.0",
+		"c@v1.0.0 b@v1.1.0",
+		"c@v1.0.0 d@v1.0.0",
+		"a e@v1.0.0",
+		"e@v1.0.0 d@v0.9.0",
+	}, "b@v1.2.0", "d@v1.1.0")
+	// The build list's information includes retraction of the selected d@v1.0.0, as listed by `go list -u`.
+	d, _ := modules.ForPath("d")
+	d.Retracted = []string{"broken"}
+	stale := FindStaleVersions(modules, g, config.Rules{})
+	if actual := staleStrings(stale); len(actual) != 2 {
+		t.Fatalf("stale %v", actual)
+	}
+	// Only the declared versions of stale requirements are looked up, beyond the build list.
+	infos := append(modules.All(),
+		&model.ModuleInfo{Path: "b", Version: "v1.0.0", Retracted: []string{""}},
+		&model.ModuleInfo{Path: "d", Version: "v0.9.0"})
+	found := FindRetractedVersions(modules, g, db.NewModuleVersions(infos), stale, config.Rules{})
+
+	var actual []string
+	for _, s := range found {
+		actual = append(actual, fmt.Sprintf("%s %s %v %v", s.Consumer, s.Requirement, s.Retracted, s.SelectedRetracted))
+	}
+	sort.Strings(actual)
+	expected := []string{
+		"a b@v1.0.0 [] []",
+		"c@v1.0.0 d@v1.0.0 [broken] [broken]",
+		"e@v1.0.0 d@v0.9.0 [] [broken]",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("found:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	for _, s := range found {
+		if s.Requirement.Path == "b" && (len(s.Retracted) != 1 || retractionRationale(s.Retracted) != "no rationale given") {
+			t.Errorf("retraction of %s: %q", s.Requirement, s.Retracted)
+		}
+	}
+}
+
+func TestFindOlderRequirements(t *testing.T) {
+	modules, g := parseBuild(t, []string{
+		"a b@v1.1.0",
+		"a c@v1.0.0",
+		"a d@v1.0.0",
+		"c@v1.0.0 b@v1.1.0",
+		"c@v1.0.0 e@v1.0.0",
+		"e@v1.0.0 b@v1.0.0",
+		"d@v1.0.0 c@v0.9.0",
+		"c@v0.9.0 b@v0.9.0",
+		"d@v1.0.0 f@v1.0.0",
+		"f@v1.0.0 b@v1.1.0",
+	}, "b@v1.3.0")
+	stale := FindStaleVersions(modules, g, config.Rules{})
+	if len(stale) != 2 {
+		t.Fatalf("stale %v", staleStrings(stale))
+	}
+	upstream := model.ModuleVersion{Path: "b", Version: "v1.2.0"}
+
+	// Requirements of the selected consumers on older versions are appended, even where they declare the
+	// selected version. Those already stale, such as e's, aren't repeated, and the unselected c@v0.9.0 isn't
+	// a consumer.
+	found := FindOlderRequirements(modules, g, upstream, stale, config.Rules{})
+	var actual []string
+	for _, s := range found[len(stale):] {
+		actual = append(actual, s.Consumer.String()+" "+s.Requirement.String()+" "+s.SelectedVersion+" "+s.HighestVersion)
+	}
+	sort.Strings(actual)
+	expected := []string{
+		"a b@v1.1.0 v1.1.0 v1.3.0",
+		"c@v1.0.0 b@v1.1.0 v1.1.0 v1.3.0",
+		"f@v1.0.0 b@v1.1.0 v1.1.0 v1.3.0",
+	}
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("found:\n%s\nexpected:\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// Consumers already requiring the version, and those not traversed, aren't included.
+	upstream.Version = "v1.1.0"
+	if found := FindOlderRequirements(modules, g, upstream, stale, config.Rules{}); len(found) != len(stale) {
+		t.Errorf("found %v", staleStrings(found))
+	}
+	upstream.Version = "v1.2.0"
+	notC := config.Rules{Exclude: []config.Rule{{Module: "c"}}}
+	for _, s := range FindOlderRequirements(modules, g, upstream, stale, notC) {
+		if s.Consumer.Path == "c" {
+			t.Errorf("found requirement of excluded %s", s.Consumer)
+		}
+	}
+}
+
+// Generates a random acyclic module graph with a main module requiring the first modules, in which each version
+// of module i requires random versions of modules with higher indexes.
+func randomBuild(b *testing.B, seed int64, nModules, nVersions, nRequirements int) (*db.Modules, *db.ModGraph) {
+	rng := rand.New(rand.NewSource(seed))
+	modPath := func(i int) string { return fmt.Sprintf("example.com/m%d", i) }
+	version := func() string { return fmt.Sprintf("v1.%d.0", rng.Intn(nVersions)) }
+	main := model.ModuleVersion{Path: "example.com/main"}
+	var rels []model.ModuleRelationship
+	for i := 0; i < nRequirements; i++ {
+		up := model.ModuleVersion{Path: modPath(i), Version: version()}
+		rels = append(rels, model.ModuleRelationship{Downstream: main, Upstream: up})
+	}
+	for i := 0; i < nModules-1; i++ {
+		for v := 0; v < nVersions; v++ {
+			down := model.ModuleVersion{Path: modPath(i), Version: fmt.Sprintf("v1.%d.0", v)}
+			for r := 0; r < nRequirements; r++ {
+				up := model.ModuleVersion{Path: modPath(i + 1 + rng.Intn(nModules-i-1)), Version: version()}
+				rels = append(rels, model.ModuleRelationship{Downstream: down, Upstream: up})
+			}
+		}
+	}
+	var latest []string
+	for i := 0; i < nModules; i++ {
+		latest = append(latest, fmt.Sprintf("%s@v1.%d.0", modPath(i), nVersions))
+	}
+	return buildModules(b, db.NewModGraph(rels), main, latest...)
+}
+
+// Stale analysis of a graph with 100k edges.
+func BenchmarkFindStaleVersions(b *testing.B) {
+	modules, g := randomBuild(b, 1, 2000, 5, 10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		FindStaleVersions(modules, g, config.Rules{})
+	}
+}
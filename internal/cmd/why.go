@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/anorth/rehab/internal/db"
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Explains why a module is in the build, printing every requirement path from the main module to the module
+// (optionally at some version). Paths ending at the version selected by MVS are marked, as are stale requirements
+// along each path. At most maxPaths paths are printed, if positive, and fewer if the search for paths hits its bound.
+func (app *Rehab) Why(root, target string, maxPaths int) error {
+	var query model.ModuleVersion
+	if err := query.Parse(target); err != nil {
+		return fmt.Errorf("bad module %s: %w", target, err)
+	}
+	modules, err := app.fetchModules(root)
+	if err != nil {
+		return err
+	}
+	modGraph, err := app.fetchModGraph(root)
+	if err != nil {
+		return err
+	}
+	mains := modules.MainVersions()
+	buildList := modGraph.BuildList(mains...)
+
+	selection, ok := buildList.Selected(query.Path)
+	if !ok {
+		fmt.Printf("# %s\n(main module does not require %s)\n", query, query.Path)
+		return nil
+	}
+	fmt.Printf("# %s (selected %s)\n", query, selection.Module.Version)
+	paths, truncated := modGraph.PathsTo(mains, query.Path, query.Version, maxPaths)
+	if len(paths) == 0 {
+		fmt.Printf("(main module does not require %s)\n", query)
+	}
+	for _, p := range paths {
+		fmt.Println(describePath(p, buildList))
+	}
+	if truncated && maxPaths > 0 && len(paths) >= maxPaths {
+		fmt.Printf("(more than %d paths, omitted)\n", len(paths))
+	} else if truncated {
+		// The search for paths is bounded, since their number can be exponential in the size of the graph.
+		fmt.Printf("(%d paths found, more omitted by the bounded search)\n", len(paths))
+	}
+	return nil
+}
+
+// Formats a requirement path, marking stale requirements and whether the path requires the selected version
+// of its target.
+func describePath(path []model.ModuleVersion, buildList *db.BuildList) string {
+	var b strings.Builder
+	for i, mv := range path {
+		if i > 0 {
+			b.WriteString(" → ")
+		}
+		b.WriteString(mv.String())
+		if i == 0 {
+			continue
+		}
+		if sel, ok := buildList.Selected(mv.Path); ok && sel.Module.Version != mv.Version {
+			fmt.Fprintf(&b, " [stale, selected %s]", sel.Module.Version)
+		}
+	}
+	target := path[len(path)-1]
+	if sel, ok := buildList.Selected(target.Path); ok && sel.Module == target {
+		b.WriteString(" (selects version)")
+	}
+	return b.String()
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v2"
+)
+
+// Name of the configuration file, in the workspace of the main module.
+const FileName = ".rehab.yaml"
+
+// Configuration of which modules rehab considers, and how it upgrades them.
+type Config struct {
+	Traverse Rules  `yaml:"traverse"` // Modules whose requirements are examined for staleness
+	Report   Rules  `yaml:"report"`   // Required modules whose stale requirements are reported
+	Propose  Rules  `yaml:"propose"`  // Required modules for which upgrades are proposed
+	Pins     []Pin  `yaml:"pins"`     // Upper bounds on upgrades of particular modules
+	Repos    []Repo `yaml:"repos"`    // Repositories of modules, overriding those resolved from their paths
+}
+
+// Include and exclude rules matching module paths.
+// A module is matched if it matches some include rule (or there are none), and no exclude rule.
+type Rules struct {
+	Include []Rule `yaml:"include"`
+	Exclude []Rule `yaml:"exclude"`
+}
+
+// A rule matching module paths with a glob pattern, as for path.Match.
+// A pattern matches a module path if it matches the whole path or a leading sequence of its elements,
+// so "golang.org/x/*" matches both golang.org/x/mod and golang.org/x/mod/v2.
+type Rule struct {
+	Module string `yaml:"module"` // Pattern for matching module paths
+	Reason string `yaml:"reason"` // Why the rule exists, shown with modules it excludes
+}
+
+// Pins upgrades of matching modules to versions no later than some version.
+// A version prefix such as "v1.4" permits every v1.4.x release.
+type Pin struct {
+	Module  string `yaml:"module"`  // Pattern for matching module paths, as for Rule
+	Version string `yaml:"version"` // Highest version, or version prefix, to upgrade to
+	Reason  string `yaml:"reason"`  // Why the module is pinned, shown with upgrades it restricts
+}
+
+// The repository containing modules under a path prefix, for module paths that don't name their repository
+// (vanity import paths).
+type Repo struct {
+	Module string `yaml:"module"` // Module path prefix corresponding to the repository root
+	URL    string `yaml:"url"`    // Repository URL with a scheme, e.g. https://github.com/owner/repo or file:///srv/repo
+}
+
+// The configuration in effect without a configuration file.
+// Requirements of Go project modules are not examined, since they're beyond the control of most users.
+func Default() *Config {
+	return &Config{
+		Traverse: Rules{
+			Exclude: []Rule{{Module: "golang.org", Reason: "Go project module"}},
+		},
+	}
+}
+
+// Loads the configuration file in a workspace directory, if any, over the default configuration.
+// Sections absent from the file keep their default values.
+func Load(dir string) (*Config, error) {
+	cfg := Default()
+	filename := filepath.Join(dir, FileName)
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed parsing %s: %w", filename, err)
+	}
+	if err := cfg.check(); err != nil {
+		return nil, fmt.Errorf("bad configuration in %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// Returns the configured repository URLs keyed by module path prefix.
+func (c *Config) RepoURLs() map[string]string {
+	urls := make(map[string]string, len(c.Repos))
+	for _, r := range c.Repos {
+		urls[r.Module] = r.URL
+	}
+	return urls
+}
+
+// Finds the pin for a module path, if any.
+func (c *Config) PinFor(modulePath string) *Pin {
+	for i := range c.Pins {
+		if matchModule(c.Pins[i].Module, modulePath) {
+			return &c.Pins[i]
+		}
+	}
+	return nil
+}
+
+func (c *Config) check() error {
+	for _, rules := range []Rules{c.Traverse, c.Report, c.Propose} {
+		for _, r := range append(rules.Include, rules.Exclude...) {
+			if _, err := path.Match(r.Module, ""); err != nil {
+				return fmt.Errorf("bad module pattern %q: %w", r.Module, err)
+			}
+		}
+	}
+	for _, p := range c.Pins {
+		if _, err := path.Match(p.Module, ""); err != nil {
+			return fmt.Errorf("bad module pattern %q: %w", p.Module, err)
+		}
+		if !semver.IsValid(p.Version) {
+			return fmt.Errorf("bad pinned version %q for %s", p.Version, p.Module)
+		}
+	}
+	for _, r := range c.Repos {
+		if isSCPLike(r.URL) {
+			return fmt.Errorf("unsupported scp-style repository URL %q for %s, use ssh://user@host/path instead",
+				r.URL, r.Module)
+		}
+		// Local repositories (file://) have no host.
+		if u, err := url.Parse(r.URL); err != nil || u.Scheme == "" || (u.Host == "" && u.Scheme != "file") {
+			return fmt.Errorf("bad repository URL %q for %s", r.URL, r.Module)
+		}
+	}
+	return nil
+}
+
+// Checks whether the rules match a module path. If not, returns the reason.
+func (r Rules) Allows(modulePath string) (bool, string) {
+	if len(r.Include) > 0 {
+		included := false
+		for _, rule := range r.Include {
+			included = included || matchModule(rule.Module, modulePath)
+		}
+		if !included {
+			return false, "not included by configuration"
+		}
+	}
+	for _, rule := range r.Exclude {
+		if matchModule(rule.Module, modulePath) {
+			if rule.Reason == "" {
+				return false, fmt.Sprintf("excluded by configuration (%s)", rule.Module)
+			}
+			return false, rule.Reason
+		}
+	}
+	return true, ""
+}
+
+// Checks whether a version is permitted by the pin.
+func (p *Pin) Allows(version string) bool {
+	return semver.Compare(version, p.Version) <= 0 || strings.HasPrefix(version, p.Version+".")
+}
+
+func (p *Pin) String() string {
+	if p.Reason == "" {
+		return fmt.Sprintf("pinned to %s", p.Version)
+	}
+	return fmt.Sprintf("pinned to %s: %s", p.Version, p.Reason)
+}
+
+// Checks whether a URL is in git's scp-like syntax, user@host:path, rather than a URL with a scheme.
+func isSCPLike(repoURL string) bool {
+	return !strings.Contains(repoURL, "://") && strings.Contains(repoURL, ":")
+}
+
+// Checks whether a pattern matches a module path or a leading sequence of its elements.
+func matchModule(pattern, modulePath string) bool {
+	prefix := modulePath
+	for {
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+		i := strings.LastIndex(prefix, "/")
+		if i < 0 {
+			return false
+		}
+		prefix = prefix[:i]
+	}
+}
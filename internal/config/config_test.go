@@ -0,0 +1,141 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadContent(t *testing.T, content string) (*Config, error) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(dir)
+}
+
+func TestLoadDefault(t *testing.T) {
+	cfg, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, reason := cfg.Traverse.Allows("golang.org/x/mod"); ok || reason == "" {
+		t.Errorf("default configuration traverses golang.org modules")
+	}
+}
+
+func TestLoadRepos(t *testing.T) {
+	cfg, err := loadContent(t, `
+repos:
+  - module: go.example.com/tool
+    url: https://github.com/example/tool
+  - module: go.example.com/local
+    url: file:///srv/git/local.git
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := cfg.RepoURLs()
+	if urls["go.example.com/tool"] != "https://github.com/example/tool" ||
+		urls["go.example.com/local"] != "file:///srv/git/local.git" {
+		t.Errorf("repo URLs %v", urls)
+	}
+
+	for url, message := range map[string]string{
+		"git@github.com:example/tool.git": "scp-style",
+		"github.com/example/tool":         "bad repository URL",
+		"https:///example/tool":           "bad repository URL",
+	} {
+		_, err := loadContent(t, "repos:\n  - module: go.example.com/tool\n    url: "+url+"\n")
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: error %v, expected %q", url, err, message)
+		}
+	}
+}
+
+func TestMatchModule(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, modulePath string
+		expected            bool
+	}{
+		{"example.com/a", "example.com/a", true},
+		{"example.com/a", "example.com/a/v2", true},
+		{"example.com/a", "example.com/a/sub/pkg", true},
+		{"example.com/a", "example.com/ab", false},
+		{"example.com/a/v2", "example.com/a", false},
+		{"example.com", "example.com/a", true},
+		{"golang.org/x/*", "golang.org/x/mod", true},
+		{"golang.org/x/*", "golang.org/x/mod/v2", true},
+		{"golang.org/x/*", "golang.org/x", false},
+		{"example.com/legacy-*", "example.com/legacy-db", true},
+		{"example.com/legacy-*", "example.com/legacy-db/v3", true},
+		{"example.com/legacy-*", "example.com/legacy", false},
+		{"example.com/legacy-*", "example.com/new/legacy-db", false},
+		{"*.example.com/a", "go.example.com/a", true},
+	} {
+		if actual := matchModule(tc.pattern, tc.modulePath); actual != tc.expected {
+			t.Errorf("%s matching %s: %v, expected %v", tc.pattern, tc.modulePath, actual, tc.expected)
+		}
+	}
+}
+
+func TestRulesAllows(t *testing.T) {
+	rules := Rules{
+		Include: []Rule{{Module: "example.com"}, {Module: "go.example.com/tool"}},
+		Exclude: []Rule{{Module: "example.com/legacy-*", Reason: "legacy modules"}, {Module: "example.com/a/old"}},
+	}
+	for _, tc := range []struct {
+		modulePath string
+		allowed    bool
+		reason     string
+	}{
+		{"example.com/a", true, ""},
+		{"go.example.com/tool/v2", true, ""},
+		{"other.com/a", false, "not included by configuration"},
+		{"go.example.com/other", false, "not included by configuration"},
+		// Exclusion applies to included modules, with the rule's reason or pattern.
+		{"example.com/legacy-db", false, "legacy modules"},
+		{"example.com/a/old", false, "excluded by configuration (example.com/a/old)"},
+	} {
+		allowed, reason := rules.Allows(tc.modulePath)
+		if allowed != tc.allowed || reason != tc.reason {
+			t.Errorf("%s: allowed %v (%q), expected %v (%q)", tc.modulePath, allowed, reason, tc.allowed, tc.reason)
+		}
+	}
+
+	// No include rules include every module.
+	if allowed, _ := (Rules{Exclude: rules.Exclude}).Allows("other.com/a"); !allowed {
+		t.Errorf("other.com/a not allowed without include rules")
+	}
+	// Include rules are checked first, so a module both not included and excluded is reported as not included.
+	other := Rules{Include: []Rule{{Module: "other.com"}}, Exclude: rules.Exclude}
+	if _, reason := other.Allows("example.com/legacy-db"); reason != "not included by configuration" {
+		t.Errorf("reason %q, expected the module to be not included", reason)
+	}
+}
+
+func TestPinAllows(t *testing.T) {
+	for _, tc := range []struct {
+		pin, version string
+		expected     bool
+	}{
+		{"v1.4", "v1.3.9", true},
+		{"v1.4", "v1.4.0", true},
+		{"v1.4", "v1.4.10", true},
+		{"v1.4", "v1.4.10-rc.1", true},
+		{"v1.4", "v1.40.0", false},
+		{"v1.4", "v1.5.0", false},
+		{"v1.4", "v2.0.0+incompatible", false},
+		{"v1", "v1.99.0", true},
+		{"v1", "v10.0.0", false},
+		{"v1.4.2", "v1.4.2", true},
+		{"v1.4.2", "v1.4.3", false},
+		{"v1.4.2", "v1.4.2-pre", true},
+	} {
+		p := &Pin{Module: "example.com/a", Version: tc.pin}
+		if actual := p.Allows(tc.version); actual != tc.expected {
+			t.Errorf("pin %s allowing %s: %v, expected %v", tc.pin, tc.version, actual, tc.expected)
+		}
+	}
+}
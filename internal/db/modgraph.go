@@ -10,12 +10,33 @@ import (
 // A module dependency graph data model.
 type ModGraph struct {
 	edges []model.ModuleRelationship // unordered
-	// TODO: indexes
+	// Indexes of edges, by downstream and upstream module path and module version.
+	byDownstreamPath    map[string][]int
+	byDownstreamVersion map[model.ModuleVersion][]int
+	byUpstreamPath      map[string][]int
+	byUpstreamVersion   map[model.ModuleVersion][]int
+	// Index of an edge requiring the highest version of each upstream module path.
+	highest map[string]int
 }
 
 func NewModGraph(rels []model.ModuleRelationship) *ModGraph {
-	g := &ModGraph{}
+	g := &ModGraph{
+		byDownstreamPath:    map[string][]int{},
+		byDownstreamVersion: map[model.ModuleVersion][]int{},
+		byUpstreamPath:      map[string][]int{},
+		byUpstreamVersion:   map[model.ModuleVersion][]int{},
+		highest:             map[string]int{},
+	}
 	g.edges = append(g.edges, rels...) // copy
+	for i, e := range g.edges {
+		g.byDownstreamPath[e.Downstream.Path] = append(g.byDownstreamPath[e.Downstream.Path], i)
+		g.byDownstreamVersion[e.Downstream] = append(g.byDownstreamVersion[e.Downstream], i)
+		g.byUpstreamPath[e.Upstream.Path] = append(g.byUpstreamPath[e.Upstream.Path], i)
+		g.byUpstreamVersion[e.Upstream] = append(g.byUpstreamVersion[e.Upstream], i)
+		if h, ok := g.highest[e.Upstream.Path]; !ok || semver.Compare(g.edges[h].Upstream.Version, e.Upstream.Version) < 0 {
+			g.highest[e.Upstream.Path] = i
+		}
+	}
 	return g
 }
 
@@ -25,41 +46,36 @@ func (g *ModGraph) Edges() []model.ModuleRelationship {
 
 // Finds all upstream dependencies of a query module (optionally: at some version).
 func (g *ModGraph) UpstreamOf(modPath, version string) []model.ModuleRelationship {
-	var result []model.ModuleRelationship
-	for _, e := range g.edges {
-		if e.Downstream.Path == modPath && (version == "" || e.Downstream.Version == version) {
-			result = append(result, e)
-		}
+	if version == "" {
+		return g.lookup(g.byDownstreamPath[modPath])
 	}
-	return result
+	return g.lookup(g.byDownstreamVersion[model.ModuleVersion{Path: modPath, Version: version}])
 }
 
 // Finds all downstream dependencies on a query module (optionally: at some version).
 func (g *ModGraph) DownstreamOf(modPath string, version string) []model.ModuleRelationship {
-	var result []model.ModuleRelationship
-	for _, e := range g.edges {
-		if e.Upstream.Path == modPath && (version == "" || e.Upstream.Version == version){
-			result = append(result, e)
-		}
+	if version == "" {
+		return g.lookup(g.byUpstreamPath[modPath])
 	}
-	return result
+	return g.lookup(g.byUpstreamVersion[model.ModuleVersion{Path: modPath, Version: version}])
 }
 
-// Returns the highest version of a module required by the graph, with one of the modules that explicitly
-// requires it.
+// Returns the highest version of a module required by any edge in the graph, with one of the modules that
+// explicitly requires it.
+// This may count edges from module versions that are not in the build list; see BuildList for
+// minimal version selection.
 func (g *ModGraph) SelectedVersion(modPath string) (string, model.ModuleVersion, error) {
-	var result string
-	var reason model.ModuleVersion
-	for _, e := range g.edges {
-		if e.Upstream.Path == modPath {
-			if result == "" || semver.Compare(result, e.Upstream.Version) < 0 {
-				result = e.Upstream.Version
-				reason = e.Downstream
-			}
-		}
+	h, ok := g.highest[modPath]
+	if !ok {
+		return "", model.ModuleVersion{}, fmt.Errorf("no dependencies on %s", modPath)
 	}
-	if result == "" {
-		return result, reason, fmt.Errorf("no dependencies on %s", modPath)
+	return g.edges[h].Upstream.Version, g.edges[h].Downstream, nil
+}
+
+func (g *ModGraph) lookup(indexes []int) []model.ModuleRelationship {
+	var result []model.ModuleRelationship
+	for _, i := range indexes {
+		result = append(result, g.edges[i])
 	}
-	return result, reason, nil
+	return result
 }
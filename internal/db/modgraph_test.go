@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Generates a random acyclic module graph, in which each version of module i requires random versions
+// of modules with higher indexes.
+func randomGraph(seed int64, nModules, nVersions, nRequirements int) []model.ModuleRelationship {
+	rng := rand.New(rand.NewSource(seed))
+	version := func() string { return fmt.Sprintf("v1.%d.0", rng.Intn(nVersions)) }
+	var rels []model.ModuleRelationship
+	for i := 0; i < nModules-1; i++ {
+		for v := 0; v < nVersions; v++ {
+			down := model.ModuleVersion{Path: fmt.Sprintf("example.com/m%d", i), Version: fmt.Sprintf("v1.%d.0", v)}
+			for r := 0; r < nRequirements; r++ {
+				j := i + 1 + rng.Intn(nModules-i-1)
+				up := model.ModuleVersion{Path: fmt.Sprintf("example.com/m%d", j), Version: version()}
+				rels = append(rels, model.ModuleRelationship{Downstream: down, Upstream: up})
+			}
+		}
+	}
+	return rels
+}
+
+// Finds edges by a linear scan, as the graph did before it was indexed.
+func scanEdges(rels []model.ModuleRelationship, match func(model.ModuleRelationship) bool) []model.ModuleRelationship {
+	var result []model.ModuleRelationship
+	for _, e := range rels {
+		if match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+func TestModGraphIndexes(t *testing.T) {
+	rels := randomGraph(1, 50, 4, 5)
+	g := NewModGraph(rels)
+	for i := 0; i < 51; i++ {
+		modPath := fmt.Sprintf("example.com/m%d", i)
+		for _, version := range []string{"", "v1.0.0", "v1.3.0", "v9.9.9"} {
+			mv := model.ModuleVersion{Path: modPath, Version: version}
+			expected := scanEdges(rels, func(e model.ModuleRelationship) bool {
+				return e.Downstream.Path == modPath && (version == "" || e.Downstream == mv)
+			})
+			if actual := g.UpstreamOf(modPath, version); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("UpstreamOf(%s, %s) = %v, expected %v", modPath, version, actual, expected)
+			}
+			expected = scanEdges(rels, func(e model.ModuleRelationship) bool {
+				return e.Upstream.Path == modPath && (version == "" || e.Upstream == mv)
+			})
+			if actual := g.DownstreamOf(modPath, version); !reflect.DeepEqual(actual, expected) {
+				t.Errorf("DownstreamOf(%s, %s) = %v, expected %v", modPath, version, actual, expected)
+			}
+		}
+	}
+}
+
+func TestSelectedVersion(t *testing.T) {
+	g := parseGraph(t, "a b@v1.0.0", "a c@v1.0.0", "c@v1.0.0 b@v1.10.0", "c@v1.0.0 b@v1.9.0")
+	version, by, err := g.SelectedVersion("b")
+	if err != nil || version != "v1.10.0" || by != mv("c@v1.0.0") {
+		t.Errorf("selected %s by %s (%v), expected v1.10.0 by c@v1.0.0", version, by, err)
+	}
+	if _, _, err := g.SelectedVersion("z"); err == nil {
+		t.Errorf("expected error for module not in graph")
+	}
+}
+
+func BenchmarkModGraphIndexes(b *testing.B) {
+	rels := randomGraph(1, 2000, 5, 10)
+	b.Run("NewModGraph", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			NewModGraph(rels)
+		}
+	})
+	g := NewModGraph(rels)
+	b.Run("UpstreamOf", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g.UpstreamOf(fmt.Sprintf("example.com/m%d", i%2000), "v1.2.0")
+		}
+	})
+	b.Run("DownstreamOf", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g.DownstreamOf(fmt.Sprintf("example.com/m%d", i%2000), "")
+		}
+	})
+}
@@ -8,27 +8,79 @@ import (
 
 // A module database.
 type Modules struct {
-	modules []*model.ModuleInfo // Main module is first, otherwise unordered
-	// TODO: indexes
+	modules []*model.ModuleInfo // Main modules are first, otherwise unordered
+	byPath  map[string]*model.ModuleInfo
 }
 
 func NewModules(modules []*model.ModuleInfo) *Modules {
-	return &Modules{modules}
+	m := &Modules{
+		modules: modules,
+		byPath:  make(map[string]*model.ModuleInfo, len(modules)),
+	}
+	for _, mod := range modules {
+		if _, ok := m.byPath[mod.Path]; !ok {
+			m.byPath[mod.Path] = mod
+		}
+	}
+	return m
 }
 
 func (m *Modules) All() []*model.ModuleInfo {
 	return m.modules[:]
 }
 
+// Returns the (first) main module.
 func (m *Modules) Main() *model.ModuleInfo {
 	return m.modules[0]
 }
 
+// Returns the main modules. There are several in a go.work workspace, and otherwise only one.
+func (m *Modules) Mains() []*model.ModuleInfo {
+	n := 1
+	for n < len(m.modules) && m.modules[n].Main {
+		n++
+	}
+	return m.modules[:n]
+}
+
+// Returns the versions of the main modules, which are the roots of the module graph.
+func (m *Modules) MainVersions() []model.ModuleVersion {
+	var mvs []model.ModuleVersion
+	for _, mod := range m.Mains() {
+		mvs = append(mvs, model.ModuleVersion{Path: mod.Path, Version: mod.Version})
+	}
+	return mvs
+}
+
+// Checks whether a module path is that of a main module.
+func (m *Modules) IsMain(path string) bool {
+	mod, ok := m.byPath[path]
+	return ok && mod.Main
+}
+
 func (m *Modules) ForPath(path string) (*model.ModuleInfo, error) {
-	for _, mod := range m.modules {
-		if mod.Path == path {
-			return mod, nil
-		}
+	if mod, ok := m.byPath[path]; ok {
+		return mod, nil
 	}
 	return nil, fmt.Errorf("no module with path %s", path)
 }
+
+// A database of specific module versions, which need not be in the build list.
+type ModuleVersions struct {
+	byVersion map[model.ModuleVersion]*model.ModuleInfo
+}
+
+func NewModuleVersions(modules []*model.ModuleInfo) *ModuleVersions {
+	m := &ModuleVersions{
+		byVersion: make(map[model.ModuleVersion]*model.ModuleInfo, len(modules)),
+	}
+	for _, mod := range modules {
+		m.byVersion[model.ModuleVersion{Path: mod.Path, Version: mod.Version}] = mod
+	}
+	return m
+}
+
+func (m *ModuleVersions) ForVersion(mv model.ModuleVersion) (*model.ModuleInfo, bool) {
+	mod, ok := m.byVersion[mv]
+	return mod, ok
+}
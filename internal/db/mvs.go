@@ -0,0 +1,246 @@
+package db
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/anorth/rehab/pkg/model"
+	"golang.org/x/mod/semver"
+)
+
+// The build list resulting from minimal version selection over a module graph.
+// See https://research.swtch.com/vgo-mvs
+type BuildList struct {
+	mains    []model.ModuleVersion
+	selected map[string]*Selection // keyed by module path
+}
+
+// A module version selected by MVS.
+type Selection struct {
+	Module     model.ModuleVersion   // The selected module version
+	RequiredBy []model.ModuleVersion // Module versions in the build graph requiring exactly the selected version
+}
+
+// Performs minimal version selection for one or more main modules (several in a go.work workspace).
+// Only module versions reachable from the main modules are considered, and the highest version of each module
+// required by any of them is selected.
+// The "go" and "toolchain" pseudo-modules in the graph are ignored.
+func (g *ModGraph) BuildList(mains ...model.ModuleVersion) *BuildList {
+	// Traverse the graph from the main modules, recording requirements of each module version reached.
+	requirers := map[model.ModuleVersion][]model.ModuleVersion{}
+	seen := map[model.ModuleVersion]struct{}{}
+	b := &BuildList{
+		mains:    mains,
+		selected: map[string]*Selection{},
+	}
+	for _, main := range mains {
+		seen[main] = struct{}{}
+		b.selected[main.Path] = &Selection{Module: main}
+	}
+	q := append([]model.ModuleVersion{}, mains...)
+	var node model.ModuleVersion
+	for len(q) > 0 {
+		node, q = q[0], q[1:]
+		for _, e := range g.UpstreamOf(node.Path, node.Version) {
+			if e.Downstream != node || isPseudoModule(e.Upstream.Path) {
+				continue
+			}
+			requirers[e.Upstream] = append(requirers[e.Upstream], node)
+			if _, ok := seen[e.Upstream]; !ok {
+				seen[e.Upstream] = struct{}{}
+				q = append(q, e.Upstream)
+			}
+		}
+	}
+
+	for mv := range seen {
+		if b.isMainPath(mv.Path) {
+			continue // The main modules are always selected, even over other versions of them that are required.
+		}
+		sel, ok := b.selected[mv.Path]
+		if !ok || semver.Compare(sel.Module.Version, mv.Version) < 0 {
+			b.selected[mv.Path] = &Selection{Module: mv}
+		}
+	}
+	for _, sel := range b.selected {
+		sel.RequiredBy = requirers[sel.Module]
+		sortRequirers(sel.RequiredBy, mains)
+	}
+	return b
+}
+
+// The main modules at the roots of the build list.
+func (b *BuildList) Mains() []model.ModuleVersion {
+	return b.mains
+}
+
+func (b *BuildList) isMainPath(modPath string) bool {
+	for _, m := range b.mains {
+		if m.Path == modPath {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the selected version of a module, if it is in the build list.
+func (b *BuildList) Selected(modPath string) (*Selection, bool) {
+	sel, ok := b.selected[modPath]
+	return sel, ok
+}
+
+// Returns all selected module versions, the main modules first, then ordered by path.
+func (b *BuildList) All() []*Selection {
+	result := make([]*Selection, 0, len(b.selected))
+	for _, sel := range b.selected {
+		result = append(result, sel)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compareModules(result[i].Module, result[j].Module, b.mains)
+	})
+	return result
+}
+
+// Checks whether a module path names one of the pseudo-modules in `go mod graph` output that
+// represent the Go version and toolchain.
+func isPseudoModule(modPath string) bool {
+	return modPath == "go" || modPath == "toolchain"
+}
+
+// Sorts module versions with the main modules first, then by path and version.
+func sortRequirers(mvs []model.ModuleVersion, mains []model.ModuleVersion) {
+	sort.Slice(mvs, func(i, j int) bool {
+		return compareModules(mvs[i], mvs[j], mains)
+	})
+}
+
+func compareModules(a, b model.ModuleVersion, mains []model.ModuleVersion) bool {
+	if aMain, bMain := contains(mains, a), contains(mains, b); aMain != bMain {
+		return aMain
+	}
+	if a.Path != b.Path {
+		return a.Path < b.Path
+	}
+	return semver.Compare(a.Version, b.Version) < 0
+}
+
+// The maximum number of partial paths PathsTo extends in searching for paths.
+const maxPartialPaths = 1 << 16
+
+// Finds requirement paths from the main modules to a target module, through module versions reachable from the
+// main modules. If version is empty, paths end at any version of the target module.
+// Each path lists module versions from a main module to the target, and paths are ordered shortest first.
+// Paths don't repeat a module version.
+// At most limit paths are returned, if limit is positive, and whether paths were omitted.
+// The number of paths can be exponential in the size of the graph, so the search is bounded, extending at most
+// maxPartialPaths partial paths, and paths beyond those found by then are omitted.
+func (g *ModGraph) PathsTo(mains []model.ModuleVersion, target, version string, limit int) ([][]model.ModuleVersion, bool) {
+	// Find the distance to a target from each module version from which one is reachable, searching backwards.
+	distance := map[model.ModuleVersion]int{}
+	var q []model.ModuleVersion
+	for _, e := range g.DownstreamOf(target, version) {
+		if _, ok := distance[e.Upstream]; !ok {
+			distance[e.Upstream] = 0
+			q = append(q, e.Upstream)
+		}
+	}
+	var node model.ModuleVersion
+	for len(q) > 0 {
+		node, q = q[0], q[1:]
+		for _, e := range g.DownstreamOf(node.Path, node.Version) {
+			if e.Upstream != node {
+				continue
+			}
+			if _, ok := distance[e.Downstream]; !ok {
+				distance[e.Downstream] = distance[node] + 1
+				q = append(q, e.Downstream)
+			}
+		}
+	}
+	// Enumerate paths forwards from the main modules, only through module versions that reach a target, extending
+	// first the partial paths with the shortest possible completion. Each path is then found by extending little
+	// more than the partial paths along it, and paths are found shortest first.
+	var paths [][]model.ModuleVersion
+	partial := &partialPaths{}
+	for _, main := range mains {
+		if d, ok := distance[main]; ok {
+			partial.push([]model.ModuleVersion{main}, d)
+		}
+	}
+	extended := 0
+	for partial.Len() > 0 {
+		path := heap.Pop(partial).(*partialPath).path
+		last := path[len(path)-1]
+		if last.Path == target && len(path) > 1 {
+			if limit > 0 && len(paths) == limit {
+				return paths, true
+			}
+			paths = append(paths, path)
+			continue
+		}
+		if extended == maxPartialPaths {
+			return paths, true
+		}
+		extended++
+		var next []model.ModuleVersion
+		for _, e := range g.UpstreamOf(last.Path, last.Version) {
+			if _, ok := distance[e.Upstream]; ok && e.Downstream == last && !contains(path, e.Upstream) {
+				next = append(next, e.Upstream)
+			}
+		}
+		sortRequirers(next, mains)
+		for _, n := range next {
+			p := make([]model.ModuleVersion, len(path), len(path)+1)
+			copy(p, path)
+			partial.push(append(p, n), distance[n])
+		}
+	}
+	return paths, false
+}
+
+// A partial path searched by PathsTo, with the length of the shortest path it may be completed to.
+type partialPath struct {
+	path  []model.ModuleVersion
+	bound int
+	seq   int // Order of insertion
+}
+
+// A priority queue of partial paths, ordered by the bound on their completed length, then the longest (nearest
+// completion) first, then insertion order.
+type partialPaths struct {
+	items []*partialPath
+	seq   int
+}
+
+// Adds a partial path, distance from a target.
+func (pp *partialPaths) push(path []model.ModuleVersion, distance int) {
+	heap.Push(pp, &partialPath{path: path, bound: len(path) + distance, seq: pp.seq})
+	pp.seq++
+}
+
+func (pp *partialPaths) Len() int { return len(pp.items) }
+func (pp *partialPaths) Less(i, j int) bool {
+	a, b := pp.items[i], pp.items[j]
+	if a.bound != b.bound {
+		return a.bound < b.bound
+	} else if len(a.path) != len(b.path) {
+		return len(a.path) > len(b.path)
+	}
+	return a.seq < b.seq
+}
+func (pp *partialPaths) Swap(i, j int)      { pp.items[i], pp.items[j] = pp.items[j], pp.items[i] }
+func (pp *partialPaths) Push(x interface{}) { pp.items = append(pp.items, x.(*partialPath)) }
+func (pp *partialPaths) Pop() interface{} {
+	last := pp.items[len(pp.items)-1]
+	pp.items = pp.items[:len(pp.items)-1]
+	return last
+}
+
+func contains(mvs []model.ModuleVersion, mv model.ModuleVersion) bool {
+	for _, m := range mvs {
+		if m == mv {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Builds a module graph from lines in the format of `go mod graph`.
+func parseGraph(t testing.TB, lines ...string) *ModGraph {
+	var rels []model.ModuleRelationship
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("bad graph line %q", line)
+		}
+		var rel model.ModuleRelationship
+		if err := rel.Downstream.Parse(fields[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := rel.Upstream.Parse(fields[1]); err != nil {
+			t.Fatal(err)
+		}
+		rels = append(rels, rel)
+	}
+	return NewModGraph(rels)
+}
+
+func mv(s string) model.ModuleVersion {
+	var m model.ModuleVersion
+	_ = m.Parse(s)
+	return m
+}
+
+func TestBuildList(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		graph    []string
+		mains    []string
+		selected map[string]string // path -> selected module version
+		required map[string]string // path -> first requirer of the selected version, "" for none
+	}{
+		{
+			name:     "highest required version",
+			graph:    []string{"a b@v1.0.0", "a c@v1.0.0", "c@v1.0.0 b@v1.2.0"},
+			mains:    []string{"a"},
+			selected: map[string]string{"a": "a", "b": "b@v1.2.0", "c": "c@v1.0.0"},
+			required: map[string]string{"a": "", "b": "c@v1.0.0", "c": "a"},
+		},
+		{
+			name: "unreachable higher version",
+			graph: []string{"a b@v1.0.0", "b@v1.0.0 c@v1.0.0",
+				"b@v1.1.0 c@v1.5.0", "c@v1.5.0 d@v1.0.0"},
+			mains:    []string{"a"},
+			selected: map[string]string{"a": "a", "b": "b@v1.0.0", "c": "c@v1.0.0"},
+			required: map[string]string{"b": "a", "c": "b@v1.0.0"},
+		},
+		{
+			name:     "cycle back to main",
+			graph:    []string{"a b@v1.0.0", "b@v1.0.0 a@v0.1.0", "a@v0.1.0 c@v1.0.0"},
+			mains:    []string{"a"},
+			selected: map[string]string{"a": "a", "b": "b@v1.0.0", "c": "c@v1.0.0"},
+			required: map[string]string{"a": "", "b": "a", "c": "a@v0.1.0"},
+		},
+		{
+			name:     "go and toolchain pseudo-modules",
+			graph:    []string{"a go@1.21", "a toolchain@go1.21.0", "a b@v1.0.0", "b@v1.0.0 go@1.20"},
+			mains:    []string{"a"},
+			selected: map[string]string{"a": "a", "b": "b@v1.0.0"},
+		},
+		{
+			name:     "workspace mains",
+			graph:    []string{"a b@v1.0.0", "w c@v1.0.0", "c@v1.0.0 b@v1.1.0", "c@v1.0.0 a@v0.2.0"},
+			mains:    []string{"a", "w"},
+			selected: map[string]string{"a": "a", "w": "w", "b": "b@v1.1.0", "c": "c@v1.0.0"},
+			required: map[string]string{"a": "", "b": "c@v1.0.0", "c": "w"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mains []model.ModuleVersion
+			for _, m := range tc.mains {
+				mains = append(mains, mv(m))
+			}
+			b := parseGraph(t, tc.graph...).BuildList(mains...)
+			if !reflect.DeepEqual(b.Mains(), mains) {
+				t.Errorf("mains %v, expected %v", b.Mains(), mains)
+			}
+
+			selected := map[string]string{}
+			for _, sel := range b.All() {
+				selected[sel.Module.Path] = sel.Module.String()
+			}
+			if !reflect.DeepEqual(selected, tc.selected) {
+				t.Errorf("selected %v, expected %v", selected, tc.selected)
+			}
+			for p, expected := range tc.required {
+				sel, ok := b.Selected(p)
+				if !ok {
+					t.Fatalf("%s not selected", p)
+				}
+				actual := ""
+				if len(sel.RequiredBy) > 0 {
+					actual = sel.RequiredBy[0].String()
+				}
+				if actual != expected {
+					t.Errorf("%s required by %q, expected %q", p, actual, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildListOrder(t *testing.T) {
+	g := parseGraph(t, "m z@v1.0.0", "m b@v1.0.0", "b@v1.0.0 a@v1.0.0")
+	b := g.BuildList(mv("m"))
+	var order []string
+	for _, sel := range b.All() {
+		order = append(order, sel.Module.Path)
+	}
+	if expected := []string{"m", "a", "b", "z"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("order %v, expected %v", order, expected)
+	}
+}
+
+func pathStrings(paths [][]model.ModuleVersion) []string {
+	var result []string
+	for _, p := range paths {
+		var mvs []string
+		for _, m := range p {
+			mvs = append(mvs, m.String())
+		}
+		result = append(result, strings.Join(mvs, " "))
+	}
+	return result
+}
+
+func TestPathsTo(t *testing.T) {
+	g := parseGraph(t,
+		"a b@v1.0.0",
+		"a c@v1.0.0",
+		"b@v1.0.0 d@v1.0.0",
+		"c@v1.0.0 b@v1.1.0",
+		"b@v1.1.0 d@v1.1.0",
+		"c@v1.0.0 e@v1.0.0",
+		"e@v1.0.0 c@v0.9.0",
+		"c@v0.9.0 d@v0.9.0",
+		"d@v1.1.0 c@v1.0.0", // A cycle
+		"e@v1.0.0 x@v1.0.0", // Not reaching d
+	)
+	mains := []model.ModuleVersion{mv("a")}
+	for _, tc := range []struct {
+		name      string
+		version   string
+		limit     int
+		expected  []string
+		truncated bool
+	}{
+		{
+			name: "all versions, shortest first",
+			expected: []string{
+				"a b@v1.0.0 d@v1.0.0",
+				"a c@v1.0.0 b@v1.1.0 d@v1.1.0",
+				"a c@v1.0.0 e@v1.0.0 c@v0.9.0 d@v0.9.0",
+			},
+		},
+		{
+			name:     "one version",
+			version:  "v1.1.0",
+			expected: []string{"a c@v1.0.0 b@v1.1.0 d@v1.1.0"},
+		},
+		{
+			name:      "limited",
+			limit:     2,
+			expected:  []string{"a b@v1.0.0 d@v1.0.0", "a c@v1.0.0 b@v1.1.0 d@v1.1.0"},
+			truncated: true,
+		},
+		{
+			name:     "limit not reached",
+			limit:    3,
+			expected: []string{"a b@v1.0.0 d@v1.0.0", "a c@v1.0.0 b@v1.1.0 d@v1.1.0", "a c@v1.0.0 e@v1.0.0 c@v0.9.0 d@v0.9.0"},
+		},
+		{
+			name:    "unrequired version",
+			version: "v2.0.0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			paths, truncated := g.PathsTo(mains, "d", tc.version, tc.limit)
+			actual := pathStrings(paths)
+			if !reflect.DeepEqual(actual, tc.expected) || truncated != tc.truncated {
+				t.Errorf("paths %q (truncated %v), expected %q (truncated %v)", actual, truncated, tc.expected, tc.truncated)
+			}
+		})
+	}
+
+	// Paths end at the first version of the target, and don't continue around the cycle through it.
+	paths, truncated := g.PathsTo(mains, "c", "", 0)
+	if actual := pathStrings(paths); !reflect.DeepEqual(actual, []string{"a c@v1.0.0"}) || truncated {
+		t.Errorf("paths %q (truncated %v)", actual, truncated)
+	}
+}
+
+// Builds a chain of n diamonds from a main module, with 2^n paths from the main module to the end of the chain.
+func diamondChain(t testing.TB, n int) *ModGraph {
+	lines := []string{"a m0@v1.0.0"}
+	for i := 0; i < n; i++ {
+		lines = append(lines,
+			fmt.Sprintf("m%d@v1.0.0 l%d@v1.0.0", i, i),
+			fmt.Sprintf("m%d@v1.0.0 r%d@v1.0.0", i, i),
+			fmt.Sprintf("l%d@v1.0.0 m%d@v1.0.0", i, i+1),
+			fmt.Sprintf("r%d@v1.0.0 m%d@v1.0.0", i, i+1))
+	}
+	return parseGraph(t, lines...)
+}
+
+func TestPathsToBounded(t *testing.T) {
+	g := diamondChain(t, 40)
+	mains := []model.ModuleVersion{mv("a")}
+	target := "m40"
+
+	paths, truncated := g.PathsTo(mains, target, "", 10)
+	if len(paths) != 10 || !truncated {
+		t.Errorf("%d paths (truncated %v), expected 10", len(paths), truncated)
+	}
+	for _, p := range paths {
+		if len(p) != 2+2*40 || p[len(p)-1].Path != target {
+			t.Errorf("path %q", pathStrings([][]model.ModuleVersion{p}))
+		}
+	}
+
+	// Without a limit, the search stops rather than enumerating 2^40 paths.
+	paths, truncated = g.PathsTo(mains, target, "", 0)
+	if !truncated || len(paths) > maxPartialPaths {
+		t.Errorf("%d paths (truncated %v), expected a bounded search", len(paths), truncated)
+	}
+}
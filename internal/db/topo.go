@@ -0,0 +1,141 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/anorth/rehab/pkg/model"
+)
+
+// Plans rounds of changes that propagate changes to a set of modules through the build.
+// Once a module changes and is released, each module requiring it must change too, to require the new release,
+// so the modules downstream of any changed module (in the build list) are included.
+// Each round's modules require only modules in earlier rounds, or in the same round where modules form a
+// requirement cycle. Each round is sorted by module path.
+func (g *ModGraph) PropagationRounds(b *BuildList, changed []string) [][]string {
+	// Module-level requirement edges from the main modules and selected versions, in both directions.
+	// Requirements on (other versions of) the main modules are ignored, since the main modules are the final
+	// consumers and are never released to their requirements.
+	consumers := append([]model.ModuleVersion{}, b.Mains()...)
+	for _, sel := range b.All() {
+		if !b.isMainPath(sel.Module.Path) {
+			consumers = append(consumers, sel.Module)
+		}
+	}
+	upstream := map[string][]string{}
+	downstream := map[string][]string{}
+	for _, consumer := range consumers {
+		for _, e := range g.UpstreamOf(consumer.Path, consumer.Version) {
+			if e.Downstream != consumer || e.Upstream.Path == consumer.Path || b.isMainPath(e.Upstream.Path) {
+				continue
+			}
+			if _, ok := b.Selected(e.Upstream.Path); !ok {
+				continue
+			}
+			upstream[e.Downstream.Path] = append(upstream[e.Downstream.Path], e.Upstream.Path)
+			downstream[e.Upstream.Path] = append(downstream[e.Upstream.Path], e.Downstream.Path)
+		}
+	}
+
+	// Find all modules downstream of those changed.
+	affected := map[string]bool{}
+	var q []string
+	for _, c := range changed {
+		if _, ok := b.Selected(c); ok && !affected[c] {
+			affected[c] = true
+			q = append(q, c)
+		}
+	}
+	var node string
+	for len(q) > 0 {
+		node, q = q[0], q[1:]
+		for _, d := range downstream[node] {
+			if !affected[d] {
+				affected[d] = true
+				q = append(q, d)
+			}
+		}
+	}
+	nodes := make([]string, 0, len(affected))
+	for n := range affected {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+
+	// Collapse cycles, then assign each component a round after all the components it requires.
+	components := stronglyConnected(nodes, func(n string) []string { return upstream[n] }, affected)
+	componentOf := map[string]int{}
+	for i, c := range components {
+		for _, n := range c {
+			componentOf[n] = i
+		}
+	}
+	// Tarjan's algorithm emits components in reverse topological order, so upstream components come first.
+	round := make([]int, len(components))
+	var rounds [][]string
+	for i, c := range components {
+		for _, n := range c {
+			for _, u := range upstream[n] {
+				if j, ok := componentOf[u]; ok && j != i && round[j]+1 > round[i] {
+					round[i] = round[j] + 1
+				}
+			}
+		}
+		for len(rounds) <= round[i] {
+			rounds = append(rounds, nil)
+		}
+		rounds[round[i]] = append(rounds[round[i]], c...)
+	}
+	for _, r := range rounds {
+		sort.Strings(r)
+	}
+	return rounds
+}
+
+// Finds the strongly connected components of a directed graph, using Tarjan's algorithm.
+// Components are returned in reverse topological order, i.e. each component follows those it has edges to.
+func stronglyConnected(nodes []string, edges func(string) []string, include map[string]bool) [][]string {
+	index := map[string]int{}
+	lowlink := map[string]int{}
+	onStack := map[string]bool{}
+	var stack []string
+	var components [][]string
+	var strongConnect func(n string)
+	strongConnect = func(n string) {
+		index[n] = len(index)
+		lowlink[n] = index[n]
+		stack = append(stack, n)
+		onStack[n] = true
+		for _, m := range edges(n) {
+			if !include[m] {
+				continue
+			}
+			if _, visited := index[m]; !visited {
+				strongConnect(m)
+				if lowlink[m] < lowlink[n] {
+					lowlink[n] = lowlink[m]
+				}
+			} else if onStack[m] && index[m] < lowlink[n] {
+				lowlink[n] = index[m]
+			}
+		}
+		if lowlink[n] == index[n] {
+			var component []string
+			for {
+				m := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[m] = false
+				component = append(component, m)
+				if m == n {
+					break
+				}
+			}
+			components = append(components, component)
+		}
+	}
+	for _, n := range nodes {
+		if _, visited := index[n]; !visited {
+			strongConnect(n)
+		}
+	}
+	return components
+}
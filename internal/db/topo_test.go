@@ -0,0 +1,68 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPropagationRounds(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		graph    []string
+		changed  []string
+		expected [][]string
+	}{
+		{
+			name:     "chain",
+			graph:    []string{"a b@v1.0.0", "b@v1.0.0 c@v1.0.0", "c@v1.0.0 d@v1.0.0"},
+			changed:  []string{"c"},
+			expected: [][]string{{"c"}, {"b"}, {"a"}},
+		},
+		{
+			name: "round after the latest requirement",
+			graph: []string{"a b@v1.0.0", "a c@v1.0.0", "b@v1.0.0 d@v1.0.0", "c@v1.0.0 b@v1.0.0",
+				"c@v1.0.0 d@v1.0.0"},
+			changed:  []string{"d"},
+			expected: [][]string{{"d"}, {"b"}, {"c"}, {"a"}},
+		},
+		{
+			name:     "independent modules share a round",
+			graph:    []string{"a b@v1.0.0", "a c@v1.0.0", "b@v1.0.0 d@v1.0.0", "c@v1.0.0 e@v1.0.0"},
+			changed:  []string{"d", "e"},
+			expected: [][]string{{"d", "e"}, {"b", "c"}, {"a"}},
+		},
+		{
+			name: "cycle collapsed into one round",
+			graph: []string{"a b@v1.0.0", "b@v1.0.0 c@v1.0.0", "c@v1.0.0 b@v1.0.0",
+				"c@v1.0.0 d@v1.0.0"},
+			changed:  []string{"d"},
+			expected: [][]string{{"d"}, {"b", "c"}, {"a"}},
+		},
+		{
+			name:     "cycle back to main",
+			graph:    []string{"a b@v1.0.0", "b@v1.0.0 c@v1.0.0", "b@v1.0.0 a@v0.1.0"},
+			changed:  []string{"c"},
+			expected: [][]string{{"c"}, {"b"}, {"a"}},
+		},
+		{
+			name:     "unselected versions ignored",
+			graph:    []string{"a b@v1.1.0", "a c@v1.0.0", "b@v1.0.0 c@v1.0.0"},
+			changed:  []string{"c"},
+			expected: [][]string{{"c"}, {"a"}},
+		},
+		{
+			name:     "changes outside the build list",
+			graph:    []string{"a b@v1.0.0"},
+			changed:  []string{"z"},
+			expected: nil,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := parseGraph(t, tc.graph...)
+			rounds := g.PropagationRounds(g.BuildList(mv("a")), tc.changed)
+			if !reflect.DeepEqual(rounds, tc.expected) {
+				t.Errorf("rounds %v, expected %v", rounds, tc.expected)
+			}
+		})
+	}
+}
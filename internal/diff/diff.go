@@ -0,0 +1,224 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Number of unchanged lines shown around each change.
+const contextLines = 3
+
+// Formats the line differences between two texts as a unified diff, or "" if they are the same.
+func Unified(oldName, newName string, old, new []byte) string {
+	a, b := splitLines(string(old)), splitLines(string(new))
+	ops := diffLines(a, b)
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change, and extend the hunk while changes are within context of each other.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops) && i-last <= 2*contextLines; i++ {
+			if ops[i].kind != ' ' {
+				last = i
+			}
+		}
+		from, to := max(first-contextLines, start), min(last+contextLines+1, len(ops))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		aStart, bStart := ops[from].aLine, ops[from].bLine
+		aLen, bLen := 0, 0
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range ops[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", op.kind, op.text)
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// Counts the lines added and removed between two texts.
+func Count(old, new []byte) (added, removed int) {
+	for _, op := range diffLines(splitLines(string(old)), splitLines(string(new))) {
+		switch op.kind {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// A line of an edit script.
+type edit struct {
+	kind         byte   // ' ' for unchanged, '-' for deleted, '+' for inserted
+	text         string // line content
+	aLine, bLine int    // zero-based line numbers in the old and new text at which the line appears
+}
+
+// Computes a minimal edit script transforming lines a into lines b, with Myers' algorithm in linear space.
+// Within each run of changes, deleted lines precede inserted lines.
+func diffLines(a, b []string) []edit {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+
+	// Order each run of changes, and number the lines.
+	ops := make([]edit, 0, len(d.ops))
+	i, j := 0, 0
+	for start := 0; start < len(d.ops); {
+		if d.ops[start].kind == ' ' {
+			ops = append(ops, edit{' ', a[i], i, j})
+			i, j, start = i+1, j+1, start+1
+			continue
+		}
+		end := start
+		for end < len(d.ops) && d.ops[end].kind != ' ' {
+			end++
+		}
+		for _, op := range d.ops[start:end] {
+			if op.kind == '-' {
+				ops = append(ops, edit{'-', a[i], i, j})
+				i++
+			}
+		}
+		for _, op := range d.ops[start:end] {
+			if op.kind == '+' {
+				ops = append(ops, edit{'+', b[j], i, j})
+				j++
+			}
+		}
+		start = end
+	}
+	return ops
+}
+
+// Accumulates an edit script, recording only the kind of each edit.
+type differ struct {
+	a, b []string
+	ops  []edit
+}
+
+// Appends the edits transforming a[aLo:aHi] into b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.ops = append(d.ops, edit{kind: ' '})
+		aLo, bLo = aLo+1, bLo+1
+	}
+	suffix := 0
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi, bHi = aHi-1, bHi-1
+		suffix++
+	}
+	switch {
+	case aLo == aHi:
+		for ; bLo < bHi; bLo++ {
+			d.ops = append(d.ops, edit{kind: '+'})
+		}
+	case bLo == bHi:
+		for ; aLo < aHi; aLo++ {
+			d.ops = append(d.ops, edit{kind: '-'})
+		}
+	default:
+		// With no common prefix or suffix, the middle point is strictly inside both ranges.
+		x, y := d.middle(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	}
+	for ; suffix > 0; suffix-- {
+		d.ops = append(d.ops, edit{kind: ' '})
+	}
+}
+
+// Finds a point on a minimal edit path from a[aLo:aHi] to b[bLo:bHi] about halfway along it, by searching
+// forwards from the start and backwards from the end until the searches overlap.
+func (d *differ) middle(aLo, aHi, bLo, bHi int) (int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	maxD := (n + m + 1) / 2
+	// The furthest x reached on each diagonal k = x - y, forwards from (0, 0) and backwards from (n, m),
+	// with backward x counted from n and diagonals from delta.
+	offset := maxD + 1
+	forward, backward := make([]int, 2*offset+1), make([]int, 2*offset+1)
+	for D := 0; D <= maxD; D++ {
+		for k := -D; k <= D; k += 2 {
+			x := forward[offset+k-1] + 1 // A deletion from diagonal k-1
+			if k == -D || (k != D && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1] // An insertion from diagonal k+1
+			}
+			x0, y0 := x, x-k
+			y := y0
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x, y = x+1, y+1
+			}
+			forward[offset+k] = x
+			if c := delta - k; delta%2 != 0 && c >= -(D-1) && c <= D-1 && x+backward[offset+c] >= n {
+				return aLo + x0, bLo + y0
+			}
+		}
+		for c := -D; c <= D; c += 2 {
+			x := backward[offset+c-1] + 1
+			if c == -D || (c != D && backward[offset+c-1] < backward[offset+c+1]) {
+				x = backward[offset+c+1]
+			}
+			y := x - c
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x, y = x+1, y+1
+			}
+			backward[offset+c] = x
+			if k := delta - c; delta%2 == 0 && k >= -D && k <= D && forward[offset+k]+x >= n {
+				return aHi - x, bHi - y
+			}
+		}
+	}
+	panic("diff: no middle point")
+}
+
+// Formats a hunk's line range, given its zero-based start line.
+func hunkRange(start, length int) string {
+	if length == 0 {
+		// An empty range names the line before it.
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,164 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func lines(n int, format string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, format+"\n", i)
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	ten := lines(10, "line %d")
+	for _, tc := range []struct {
+		name     string
+		old, new string
+		expected string
+	}{
+		{name: "same", old: ten, new: ten},
+		{name: "empty"},
+		{
+			name:     "change",
+			old:      ten,
+			new:      strings.Replace(ten, "line 5\n", "line five\n", 1),
+			expected: "@@ -2,7 +2,7 @@\n line 2\n line 3\n line 4\n-line 5\n+line five\n line 6\n line 7\n line 8\n",
+		},
+		{
+			name:     "insert at start",
+			old:      ten,
+			new:      "line 0\n" + ten,
+			expected: "@@ -1,3 +1,4 @@\n+line 0\n line 1\n line 2\n line 3\n",
+		},
+		{
+			name:     "delete at end",
+			old:      ten,
+			new:      lines(9, "line %d"),
+			expected: "@@ -7,4 +7,3 @@\n line 7\n line 8\n line 9\n-line 10\n",
+		},
+		{
+			name:     "append to empty",
+			new:      "a\nb\n",
+			expected: "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "delete all",
+			old:      "a\n",
+			expected: "@@ -1 +0,0 @@\n-a\n",
+		},
+		{
+			name: "changes within context share a hunk",
+			old:  ten,
+			new:  strings.Replace(strings.Replace(ten, "line 2\n", "", 1), "line 8\n", "line eight\n", 1),
+			expected: "@@ -1,10 +1,9 @@\n line 1\n-line 2\n line 3\n line 4\n line 5\n line 6\n line 7\n" +
+				"-line 8\n+line eight\n line 9\n line 10\n",
+		},
+		{
+			name: "distant changes make hunks",
+			old:  lines(20, "line %d"),
+			new:  strings.Replace(strings.Replace(lines(20, "line %d"), "line 2\n", "line two\n", 1), "line 18\n", "", 1),
+			expected: "@@ -1,5 +1,5 @@\n line 1\n-line 2\n+line two\n line 3\n line 4\n line 5\n" +
+				"@@ -15,6 +15,5 @@\n line 15\n line 16\n line 17\n-line 18\n line 19\n line 20\n",
+		},
+		{
+			name:     "replaced lines delete first",
+			old:      "a\nb\nc\nd\n",
+			new:      "a\nx\ny\nz\nd\n",
+			expected: "@@ -1,4 +1,5 @@\n a\n-b\n-c\n+x\n+y\n+z\n d\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			expected := tc.expected
+			if expected != "" {
+				expected = "--- a/go.mod\n+++ b/go.mod\n" + expected
+			}
+			actual := Unified("a/go.mod", "b/go.mod", []byte(tc.old), []byte(tc.new))
+			if actual != expected {
+				t.Errorf("diff:\n%s\nexpected:\n%s", actual, expected)
+			}
+		})
+	}
+}
+
+// Computes the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] > cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func randomLines(r *rand.Rand, n, alphabet int) []string {
+	result := make([]string, n)
+	for i := range result {
+		result[i] = string(rune('a' + r.Intn(alphabet)))
+	}
+	return result
+}
+
+func TestDiffLinesMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(r, r.Intn(30), 1+r.Intn(5)), randomLines(r, r.Intn(30), 1+r.Intn(5))
+		ops := diffLines(a, b)
+		var gotA, gotB []string
+		kept := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				if op.aLine != len(gotA) || a[op.aLine] != op.text {
+					t.Fatalf("%q → %q: bad old line %+v", a, b, op)
+				}
+				gotA = append(gotA, op.text)
+			}
+			if op.kind != '-' {
+				if op.bLine != len(gotB) || b[op.bLine] != op.text {
+					t.Fatalf("%q → %q: bad new line %+v", a, b, op)
+				}
+				gotB = append(gotB, op.text)
+			}
+			if op.kind == ' ' {
+				kept++
+			}
+		}
+		if len(gotA) != len(a) || len(gotB) != len(b) {
+			t.Fatalf("%q → %q: script covers %q → %q", a, b, gotA, gotB)
+		}
+		if expected := lcsLength(a, b); kept != expected {
+			t.Fatalf("%q → %q: kept %d lines, expected %d", a, b, kept, expected)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	added, removed := Count([]byte("a\nb\nc\n"), []byte("a\nx\ny\nc\nd\n"))
+	if added != 3 || removed != 1 {
+		t.Errorf("added %d, removed %d", added, removed)
+	}
+}
+
+func BenchmarkUnified(b *testing.B) {
+	// A large go.sum-like file with scattered changes.
+	old := lines(20000, "example.com/m%d v1.0.0 h1:abc=")
+	new := strings.Replace(old, "m100 v1.0.0", "m100 v1.1.0", 1)
+	new = strings.Replace(new, "m15000 v1.0.0", "m15000 v1.2.0", 1)
+	new += "example.com/z v1.0.0 h1:xyz=\n"
+	for i := 0; i < b.N; i++ {
+		Unified("a", "b", []byte(old), []byte(new))
+	}
+}
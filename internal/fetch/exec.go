@@ -3,12 +3,19 @@ package fetch
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 )
 
 // Executes a command in a specified working directory.
 func Exec(workingDir string, cmd string, args ...string) (stdout []byte, err error) {
+	return ExecEnv(workingDir, nil, cmd, args...)
+}
+
+// Executes a command in a specified working directory, with environment variables (as "key=value")
+// added to those of this process.
+func ExecEnv(workingDir string, env []string, cmd string, args ...string) (stdout []byte, err error) {
 	if !filepath.IsAbs(workingDir) {
 		if workingDir, err = filepath.Abs(workingDir); err != nil {
 			return nil, err
@@ -18,6 +25,9 @@ func Exec(workingDir string, cmd string, args ...string) (stdout []byte, err err
 	var stdoutBuffer bytes.Buffer
 	execCmd := exec.Command(cmd, args...)
 	execCmd.Dir = workingDir
+	if len(env) > 0 {
+		execCmd.Env = append(os.Environ(), env...)
+	}
 	execCmd.Stdout = &stdoutBuffer
 	// Stderr is left to the console.
 
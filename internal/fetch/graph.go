@@ -14,8 +14,13 @@ import (
 // The main module at modulePath is returned first.
 // See https://go.dev/ref/mod#go-mod-graph
 func ListModuleDependencies(modulePath string) ([]model.ModuleRelationship, error) {
+	return listModuleDependencies(modulePath, nil)
+}
+
+// Lists the module requirement graph, with environment variables (as "key=value") added to those of this process.
+func listModuleDependencies(modulePath string, env []string) ([]model.ModuleRelationship, error) {
 	log.Printf("fetching module requirement graph for %s", modulePath)
-	raw, err := Exec(modulePath, "go", "mod", "graph")
+	raw, err := ExecEnv(modulePath, env, "go", "mod", "graph")
 	if err != nil {
 		return nil, fmt.Errorf("failed listing dependencies for %s: %w", modulePath, err)
 	}
@@ -9,11 +9,18 @@ import (
 	"github.com/anorth/rehab/pkg/model"
 )
 
-// Lists all active modules under a path. The main module is the one contained in modulePath, and the active
-// modules are the main module and its dependencies.
-func ListModules(modulePath string) ([]*model.ModuleInfo, error) {
+// Lists all active modules under a path. The main module is the one contained in modulePath, or in a go.work
+// workspace the main modules are all the workspace's modules, listed first. The active modules are the main
+// modules and their dependencies.
+// If versions is set, the information includes all available versions of each module. Errors listing the
+// versions of a module (such as an unpublished main module) are then reported in the corresponding ModuleInfo.
+func ListModules(modulePath string, versions bool) ([]*model.ModuleInfo, error) {
 	log.Printf("fetching module information for %s", modulePath)
-	raw, err := Exec(modulePath, "go", "list", "-json", "-u", "-m", "all")
+	args := []string{"list", "-json", "-u", "-m"}
+	if versions {
+		args = append(args, "-e", "-versions")
+	}
+	raw, err := Exec(modulePath, "go", append(args, "all")...)
 	if err != nil {
 		return nil, fmt.Errorf("failed listing packages for %s: %w", modulePath, err)
 	}
@@ -27,6 +34,28 @@ func ListModules(modulePath string) ([]*model.ModuleInfo, error) {
 	return moduleList, nil
 }
 
+// Lists information about specific module versions, which need not be in the build list of the module at
+// modulePath. The information includes any retraction of each version.
+// Errors loading individual module versions are reported in the corresponding ModuleInfo.
+func ListModuleVersions(modulePath string, versions []model.ModuleVersion) ([]*model.ModuleInfo, error) {
+	log.Printf("fetching information for %d module versions", len(versions))
+	args := []string{"list", "-json", "-e", "-m", "-retracted"}
+	for _, v := range versions {
+		args = append(args, v.String())
+	}
+	raw, err := Exec(modulePath, "go", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing module versions: %w", err)
+	}
+
+	raw = fixListJson(raw)
+	var moduleList []*model.ModuleInfo
+	if err = json.Unmarshal(raw, &moduleList); err != nil {
+		return nil, fmt.Errorf("failed parsing module information: %w", err)
+	}
+	return moduleList, nil
+}
+
 // Lists all packages transitively depended upon by a path.
 func ListPackages(packagePath string) ([]*model.PackageInfo, error) {
 	log.Printf("fetching package information for %s", packagePath)
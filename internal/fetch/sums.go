@@ -0,0 +1,129 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anorth/rehab/pkg/model"
+	"golang.org/x/mod/modfile"
+)
+
+// Computes the go.sum content matching a go.mod file, starting from some original go.sum content.
+// The module graph of the go.mod is resolved in a scratch directory against the configured GOPROXY.
+// The result includes checksums for the go.mod file of every module version in the graph, and for the content
+// of the selected version of every module in the build list.
+// Without the module's packages, the modules providing imported packages can't be told apart, so all are
+// downloaded. This costs a download of each module, and may record content checksums that "go mod tidy" would
+// omit, but they're harmless, and a requirement bumped to a version the original didn't checksum builds.
+// Checksums for module versions no longer in the graph are dropped.
+// A go.mod replacing a module by a local directory can't be resolved, and returns a *LocalReplaceError.
+func ResolveSums(goMod, goSum []byte) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "rehab-sums-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// Replacements by local directories can't be resolved outside their workspace, and dropping them would
+	// resolve a different graph.
+	modFile, err := modfile.Parse("go.mod", goMod, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing go.mod: %w", err)
+	}
+	for _, r := range modFile.Replace {
+		if modfile.IsDirectoryPath(r.New.Path) {
+			return nil, &LocalReplaceError{Module: r.Old.Path, Dir: r.New.Path}
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), goMod, 0644); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.sum"), goSum, 0644); err != nil {
+		return nil, err
+	}
+	env := []string{"GOFLAGS=-mod=mod", "GOWORK=off"}
+
+	// Listing the build list records checksums for the go.mod files in the graph.
+	log.Printf("resolving module graph for %s", modFile.Module.Mod.Path)
+	raw, err := ExecEnv(dir, env, "go", "list", "-json", "-m", "all")
+	if err != nil {
+		return nil, fmt.Errorf("failed resolving module graph: %w", err)
+	}
+	var buildList []*model.ModuleInfo
+	if err = json.Unmarshal(fixListJson(raw), &buildList); err != nil {
+		return nil, fmt.Errorf("failed parsing module information: %w", err)
+	}
+
+	// Download the selected version of every module in the build list, or its replacement.
+	if _, err := ExecEnv(dir, env, "go", "mod", "download", "all"); err != nil {
+		return nil, fmt.Errorf("failed downloading modules: %w", err)
+	}
+
+	// Drop checksums for module versions no longer in the graph.
+	graph, err := listModuleDependencies(dir, env)
+	if err != nil {
+		return nil, err
+	}
+	inGraph := map[model.ModuleVersion]bool{}
+	for _, rel := range graph {
+		inGraph[rel.Upstream] = true
+	}
+	for _, mod := range buildList {
+		if mod.Replace != nil {
+			inGraph[model.ModuleVersion{Path: mod.Replace.Path, Version: mod.Replace.Version}] = true
+		}
+	}
+	newSum, err := ioutil.ReadFile(filepath.Join(dir, "go.sum"))
+	if err != nil {
+		return nil, err
+	}
+	var result bytes.Buffer
+	for _, line := range parseSums(newSum) {
+		mv := model.ModuleVersion{Path: line.Path, Version: strings.TrimSuffix(line.Version, "/go.mod")}
+		if inGraph[mv] {
+			result.WriteString(line.Text)
+			result.WriteString("\n")
+		}
+	}
+	return result.Bytes(), nil
+}
+
+// An error resolving checksums for a go.mod that replaces a module by a local directory, which is available only
+// in the go.mod's own repository or workspace.
+type LocalReplaceError struct {
+	Module string // Path of the replaced module
+	Dir    string // Directory replacing it
+}
+
+func (e *LocalReplaceError) Error() string {
+	return fmt.Sprintf("%s is replaced by local directory %s, so checksums can't be resolved outside the workspace",
+		e.Module, e.Dir)
+}
+
+// A line of a go.sum file.
+type sumLine struct {
+	Path    string
+	Version string // version, possibly with "/go.mod" suffix
+	Text    string // the full line
+}
+
+func parseSums(goSum []byte) []sumLine {
+	var lines []sumLine
+	scanner := bufio.NewScanner(bytes.NewBuffer(goSum))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		lines = append(lines, sumLine{Path: fields[0], Version: fields[1], Text: strings.Join(fields, " ")})
+	}
+	return lines
+}
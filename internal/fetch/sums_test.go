@@ -0,0 +1,131 @@
+package fetch
+
+import (
+	"archive/zip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Sets an environment variable for the rest of a test.
+func setenv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, previous)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+// Writes a module version with a go.mod file and a single package to a GOPROXY directory.
+func writeProxyModule(t *testing.T, proxy, path, version, goMod string) {
+	dir := filepath.Join(proxy, filepath.FromSlash(path), "@v")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := ioutil.ReadFile(filepath.Join(dir, "list"))
+	files := map[string]string{
+		"list":            string(list) + version + "\n",
+		version + ".info": `{"Version":"` + version + `"}`,
+		version + ".mod":  goMod,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	zipFile, err := os.Create(filepath.Join(dir, version+".zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipFile.Close()
+	w := zip.NewWriter(zipFile)
+	for name, content := range map[string]string{"go.mod": goMod, "lib.go": "package lib\n"} {
+		f, err := w.Create(path + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolveSumsLocalReplace(t *testing.T) {
+	goMod := []byte("module example.com/a\n\ngo 1.16\n\nrequire example.com/b v1.0.0\n\nreplace example.com/b => ../b\n")
+	_, err := ResolveSums(goMod, nil)
+	var replaceErr *LocalReplaceError
+	if !errors.As(err, &replaceErr) || replaceErr.Module != "example.com/b" || replaceErr.Dir != "../b" {
+		t.Errorf("error %v, expected local replacement of example.com/b", err)
+	}
+}
+
+func TestResolveSumsOutsideWorkspace(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	// A workspace in the environment doesn't apply to the scratch module.
+	setenv(t, "GOWORK", filepath.Join(t.TempDir(), "missing", "go.work"))
+
+	// Checksums of module versions not in the graph are dropped.
+	goMod := []byte("module example.com/a\n\ngo 1.16\n")
+	goSum := []byte("example.com/old v1.0.0 h1:AAAA=\nexample.com/old v1.0.0/go.mod h1:BBBB=\n")
+	sums, err := ResolveSums(goMod, goSum)
+	if err != nil || len(sums) != 0 {
+		t.Errorf("sums %q (%v), expected none", sums, err)
+	}
+}
+
+func TestResolveSumsBumpedRequirement(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found")
+	}
+	proxy := t.TempDir()
+	writeProxyModule(t, proxy, "example.com/b", "v1.0.0", "module example.com/b\n\ngo 1.16\n")
+	writeProxyModule(t, proxy, "example.com/b", "v1.1.0", "module example.com/b\n\ngo 1.16\n\nrequire example.com/c v1.0.0\n")
+	writeProxyModule(t, proxy, "example.com/c", "v1.0.0", "module example.com/c\n\ngo 1.16\n")
+	writeProxyModule(t, proxy, "example.com/fork", "v1.0.1", "module example.com/c\n\ngo 1.16\n")
+	modCache := t.TempDir()
+	setenv(t, "GOPROXY", "file://"+filepath.ToSlash(proxy))
+	setenv(t, "GOSUMDB", "off")
+	setenv(t, "GOMODCACHE", modCache)
+	setenv(t, "GOWORK", "off")
+	t.Cleanup(func() {
+		// The module cache is read-only.
+		if _, err := ExecEnv(modCache, nil, "go", "clean", "-modcache"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// The original go.sum has only the go.mod checksum of the previous requirement.
+	goMod := []byte("module example.com/a\n\ngo 1.16\n\nrequire example.com/b v1.1.0\n\n" +
+		"replace example.com/c => example.com/fork v1.0.1\n")
+	goSum := []byte("example.com/b v1.0.0/go.mod h1:AAAA=\n")
+	sums, err := ResolveSums(goMod, goSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modules []string
+	for _, line := range parseSums(sums) {
+		modules = append(modules, line.Path+" "+line.Version)
+	}
+	expected := []string{
+		"example.com/b v1.1.0", "example.com/b v1.1.0/go.mod",
+		"example.com/fork v1.0.1", "example.com/fork v1.0.1/go.mod",
+	}
+	if strings.Join(modules, ", ") != strings.Join(expected, ", ") {
+		t.Errorf("sums for %v, expected %v:\n%s", modules, expected, sums)
+	}
+}
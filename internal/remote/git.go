@@ -0,0 +1,206 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/anorth/rehab/internal/fetch"
+)
+
+// A repository reached with plain git, at any URL git understands (including file:// for local bare repositories).
+// The repository is cloned to a temporary directory, where commits are made before pushing them.
+// There's no API for pull requests, so instructions for opening one are printed instead.
+type Git struct {
+	url           string
+	dir           string // The module's path within the repository, "" for the root
+	clone         string // Path of the local clone
+	defaultBranch string
+}
+
+// Opens the git repository containing a module, given the URL for some prefix of the module path.
+// Repositories are tried at the URL and then at URLs extended by successive elements of the rest of the module
+// path, with and without a ".git" suffix. The first that exists is cloned.
+func OpenGit(ctx context.Context, modulePath, prefix, prefixURL string) (*Git, error) {
+	rest := strings.Trim(strings.TrimPrefix(modulePath, prefix), "/")
+	var elements []string
+	if rest != "" {
+		elements = strings.Split(rest, "/")
+	}
+	for i := 0; i <= len(elements); i++ {
+		base := strings.TrimSuffix(strings.Join(append([]string{prefixURL}, elements[:i]...), "/"), "/")
+		for _, repoURL := range []string{base, base + ".git"} {
+			if !probeGit(repoURL) {
+				continue
+			}
+			return cloneGit(repoURL, strings.Join(elements[i:], "/"))
+		}
+	}
+	return nil, fmt.Errorf("no git repository found for %s at %s", modulePath, prefixURL)
+}
+
+// Checks whether a URL names a git repository, without prompting for credentials.
+func probeGit(repoURL string) bool {
+	cmd := exec.Command("git", "ls-remote", "--quiet", repoURL, "HEAD")
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	return cmd.Run() == nil
+}
+
+func cloneGit(repoURL, dir string) (*Git, error) {
+	clone, err := ioutil.TempDir("", "rehab-git-")
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("cloning %s", repoURL)
+	if _, err := fetch.Exec(clone, "git", "clone", "--quiet", "--depth", "1", repoURL, "."); err != nil {
+		_ = os.RemoveAll(clone)
+		return nil, fmt.Errorf("failed cloning %s: %w", repoURL, err)
+	}
+	branch, err := fetch.Exec(clone, "git", "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		_ = os.RemoveAll(clone)
+		return nil, fmt.Errorf("failed finding default branch of %s: %w", repoURL, err)
+	}
+	return &Git{
+		url:           repoURL,
+		dir:           dir,
+		clone:         clone,
+		defaultBranch: strings.TrimSpace(string(branch)),
+	}, nil
+}
+
+func (r *Git) URL() string {
+	return r.url
+}
+
+func (r *Git) Dir() string {
+	return r.dir
+}
+
+func (r *Git) FindGoMod(ctx context.Context, modulePath string) (string, error) {
+	return findGoMod(modulePath, r.dir, r.URL(), func(name string) ([]byte, error) {
+		content, err := ioutil.ReadFile(filepath.Join(r.clone, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return content, err
+	})
+}
+
+func (r *Git) EditFiles(ctx context.Context, names []string, edit func(map[string][]byte) (map[string][]byte, error)) (string, error) {
+	if _, err := r.git("checkout", "--quiet", "--detach", "origin/"+r.defaultBranch); err != nil {
+		return "", err
+	}
+	original := map[string][]byte{}
+	for _, name := range names {
+		content, err := ioutil.ReadFile(filepath.Join(r.clone, filepath.FromSlash(name)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+		original[name] = content
+	}
+
+	modified, err := edit(original)
+	if err != nil {
+		return "", fmt.Errorf("failed editing files: %w", err)
+	}
+	var changed []string
+	for _, name := range names {
+		modifiedContent, ok := modified[name]
+		if !ok {
+			continue
+		}
+		if content, ok := original[name]; ok && bytes.Equal(content, modifiedContent) {
+			continue
+		}
+		filename := filepath.Join(r.clone, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filename, modifiedContent, 0644); err != nil {
+			return "", err
+		}
+		changed = append(changed, name)
+	}
+	if len(changed) == 0 {
+		return "", nil
+	}
+
+	if _, err := r.git(append([]string{"add", "--"}, changed...)...); err != nil {
+		return "", err
+	}
+	commitArgs := []string{"commit", "--quiet", "--message", commitMessage}
+	if _, err := r.git("config", "user.email"); err != nil {
+		// No identity is configured, so commit with one for rehab.
+		commitArgs = append([]string{"-c", "user.name=Rehab", "-c", "user.email=rehab@localhost"}, commitArgs...)
+	}
+	if _, err := r.git(commitArgs...); err != nil {
+		return "", err
+	}
+	sha, err := r.git("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	log.Printf("committed %s", sha)
+	return sha, nil
+}
+
+func (r *Git) MakeBranch(ctx context.Context, commit, name string) (string, error) {
+	refName := "refs/heads/" + name
+	existing, err := r.git("ls-remote", "--heads", "origin", refName)
+	if err != nil {
+		return "", err
+	}
+	pushArgs := []string{"push", "--quiet", "origin", commit + ":" + refName}
+	if existing != "" {
+		if _, err := r.git("fetch", "--quiet", "origin", refName); err != nil {
+			return "", err
+		}
+		message, err := r.git("log", "-1", "--format=%B", "FETCH_HEAD")
+		if err != nil {
+			return "", err
+		}
+		if !isRehabCommit(message) {
+			return "", fmt.Errorf("failed to push ref %s: branch exists with head not pushed by rehab", refName)
+		}
+		log.Printf("updating branch %s", refName)
+		pushArgs = append(pushArgs, "--force")
+	}
+	log.Printf("pushing branch %s at %s", refName, commit)
+	if _, err := r.git(pushArgs...); err != nil {
+		return "", fmt.Errorf("failed to push ref %s %s: %w", refName, commit, err)
+	}
+	return refName, nil
+}
+
+func (r *Git) CompareBranch(refName, title, message string) (string, error) {
+	branch := strings.TrimPrefix(refName, "refs/heads/")
+	return fmt.Sprintf("%s, branch %s (merge into %s)", r.url, branch, r.defaultBranch), nil
+}
+
+// Prints instructions for opening a pull request, since plain git has no API for them, and returns "".
+func (r *Git) MakePull(ctx context.Context, refName, title, message string) (string, error) {
+	branch := strings.TrimPrefix(refName, "refs/heads/")
+	fmt.Printf("Open a pull request from %s into %s at %s, titled \"%s\":\n%s\n",
+		branch, r.defaultBranch, r.url, title, message)
+	return "", nil
+}
+
+// Removes the local clone.
+func (r *Git) Close() error {
+	return os.RemoveAll(r.clone)
+}
+
+// Runs a git command in the clone, returning its trimmed output.
+func (r *Git) git(args ...string) (string, error) {
+	out, err := fetch.Exec(r.clone, "git", args...)
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,175 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs git in a directory, failing the test on error, and returns its trimmed output.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Makes a bare repository with a commit on the main branch adding files, returning its file:// URL.
+func makeBareRepo(t *testing.T, dir string, files map[string]string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, dir, "init", "--quiet", "--bare", "--initial-branch=main")
+	work := t.TempDir()
+	runGit(t, work, "init", "--quiet", "--initial-branch=main")
+	for name, content := range files {
+		filename := filepath.Join(work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "--quiet", "--message", "Initial commit")
+	runGit(t, work, "push", "--quiet", "file://"+dir, "main")
+	return "file://" + dir
+}
+
+func TestOpenGit(t *testing.T) {
+	srv := t.TempDir()
+	makeBareRepo(t, filepath.Join(srv, "owner", "dotgit.git"), map[string]string{
+		"go.mod":    "module example.com/owner/dotgit\n",
+		"v3/go.mod": "module example.com/owner/dotgit/v3\n",
+	})
+	makeBareRepo(t, filepath.Join(srv, "owner", "plain"), map[string]string{"sub/go.mod": "module example.com/owner/plain/sub/v2\n"})
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		modulePath string
+		url        string
+		dir        string
+		goMod      string // "" if no go.mod declares the module
+	}{
+		{"example.com/owner/dotgit", "file://" + srv + "/owner/dotgit.git", "", "go.mod"},
+		{"example.com/owner/dotgit/v3", "file://" + srv + "/owner/dotgit.git", "v3", "v3/go.mod"},
+		// The root go.mod declares the v1 module.
+		{"example.com/owner/dotgit/v2", "file://" + srv + "/owner/dotgit.git", "v2", ""},
+		{"example.com/owner/plain/sub/v2", "file://" + srv + "/owner/plain", "sub/v2", "sub/go.mod"},
+	} {
+		r, err := OpenGit(ctx, tc.modulePath, "example.com", "file://"+srv)
+		if err != nil {
+			t.Errorf("%s: %s", tc.modulePath, err)
+			continue
+		}
+		goMod, err := r.FindGoMod(ctx, tc.modulePath)
+		// Git finds a local repository with a .git suffix without it, so the suffix may not be probed.
+		if strings.TrimSuffix(r.URL(), ".git") != strings.TrimSuffix(tc.url, ".git") ||
+			r.Dir() != tc.dir || goMod != tc.goMod || (err != nil) != (tc.goMod == "") {
+			t.Errorf("%s: opened %s dir %q go.mod %q (%v), expected %s dir %q go.mod %q", tc.modulePath,
+				r.URL(), r.Dir(), goMod, err, tc.url, tc.dir, tc.goMod)
+		}
+		if err := r.Close(); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Stat(r.clone); !os.IsNotExist(err) {
+			t.Errorf("clone %s not removed", r.clone)
+		}
+	}
+
+	if _, err := OpenGit(ctx, "example.com/owner/missing", "example.com", "file://"+srv); err == nil {
+		t.Errorf("expected error for missing repository")
+	}
+}
+
+func TestGitEditAndBranch(t *testing.T) {
+	repoDir := filepath.Join(t.TempDir(), "repo.git")
+	repoURL := makeBareRepo(t, repoDir, map[string]string{"go.mod": "module x\n"})
+	ctx := context.Background()
+	r, err := OpenGit(ctx, "example.com/repo", "example.com/repo", repoURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	edit := func(content string) string {
+		sha, err := r.EditFiles(ctx, []string{"go.mod", "go.sum"}, func(files map[string][]byte) (map[string][]byte, error) {
+			if string(files["go.mod"]) != "module x\n" {
+				t.Errorf("edit from %q, expected the default branch's go.mod", files["go.mod"])
+			}
+			if _, ok := files["go.sum"]; ok {
+				t.Errorf("missing file read")
+			}
+			return map[string][]byte{"go.mod": []byte(content), "go.sum": []byte("y v1.0.0 h1:\n")}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sha
+	}
+
+	// Unchanged files make no commit.
+	sha, err := r.EditFiles(ctx, []string{"go.mod"}, func(files map[string][]byte) (map[string][]byte, error) {
+		return files, nil
+	})
+	if err != nil || sha != "" {
+		t.Fatalf("unchanged edit committed %q (%v)", sha, err)
+	}
+
+	// A new branch is pushed with the commit.
+	sha = edit("module x // 1\n")
+	ref, err := r.MakeBranch(ctx, sha, "rehab/x")
+	if err != nil || ref != "refs/heads/rehab/x" {
+		t.Fatalf("made %q (%v)", ref, err)
+	}
+	if head := runGit(t, repoDir, "rev-parse", "refs/heads/rehab/x"); head != sha {
+		t.Errorf("branch at %s, expected %s", head, sha)
+	}
+	if files := runGit(t, repoDir, "show", "--name-only", "--format=%B", sha); !strings.Contains(files, commitMarker) ||
+		!strings.Contains(files, "go.sum") {
+		t.Errorf("commit %s", files)
+	}
+
+	// A branch pushed by rehab is force-updated, from the default branch rather than the earlier commit.
+	sha2 := edit("module x // 2\n")
+	if _, err := r.MakeBranch(ctx, sha2, "rehab/x"); err != nil {
+		t.Fatal(err)
+	}
+	if head := runGit(t, repoDir, "rev-parse", "refs/heads/rehab/x"); head != sha2 {
+		t.Errorf("branch at %s, expected %s", head, sha2)
+	}
+
+	// Plain git has no pull requests.
+	if pullURL, err := r.MakePull(ctx, ref, "Title", "Body"); pullURL != "" || err != nil {
+		t.Errorf("pull request %q (%v)", pullURL, err)
+	}
+
+	// A branch with a head pushed by anyone else is left alone, even with rehab's subject line.
+	work := t.TempDir()
+	runGit(t, work, "clone", "--quiet", repoURL, ".")
+	for i, message := range []string{"Bump dependencies", "Upgrade module requirements"} {
+		branch := fmt.Sprintf("rehab/human%d", i)
+		runGit(t, work, "checkout", "--quiet", "-b", branch, "origin/main")
+		runGit(t, work, "commit", "--quiet", "--allow-empty", "--message", message)
+		runGit(t, work, "push", "--quiet", "origin", branch)
+		human := runGit(t, repoDir, "rev-parse", "refs/heads/"+branch)
+		if _, err := r.MakeBranch(ctx, edit("module x // 3\n"), branch); err == nil {
+			t.Errorf("expected error replacing a branch with head %q", message)
+		}
+		if head := runGit(t, repoDir, "rev-parse", "refs/heads/"+branch); head != human {
+			t.Errorf("branch with head %q moved to %s", message, head)
+		}
+	}
+}
@@ -0,0 +1,605 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+var ghRepoRe = regexp.MustCompile("^github\\.com/([\\w.-]+)/([\\w.-]+)(/.*)?$")
+
+// How long to wait for GitHub to create a fork.
+const forkTimeout = 2 * time.Minute
+
+// A repository hosted on GitHub.
+// If the token lacks permission to push to the repository, branches are pushed to a fork of it instead,
+// and pull requests are made from the fork.
+type GitHub struct {
+	client  *github.Client
+	info    *github.Repository
+	push    *github.Repository // The repository to which branches are pushed: the repository itself, or a fork once made
+	forkOrg string             // Organization for a fork, or "" for the token owner's account
+	dir     string             // The module's path within the repository, "" for the root
+}
+
+// Opens the GitHub repository containing a module. If the token can't push to the repository, it is forked
+// into forkOrg, or the token owner's account if forkOrg is empty (an existing fork there is reused).
+// The fork is made when first needed, by EditFiles.
+func OpenGitHub(ctx context.Context, path, token, forkOrg string) (*GitHub, error) {
+	match := ghRepoRe.FindStringSubmatch(path)
+	if len(match) == 0 {
+		return nil, fmt.Errorf("%s isn't a GitHub repo path", path)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("a GitHub token is required to push to %s", path)
+	}
+
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	tc := oauth2.NewClient(ctx, ts)
+	return openGitHub(ctx, github.NewClient(tc), path, forkOrg)
+}
+
+func openGitHub(ctx context.Context, client *github.Client, path, forkOrg string) (*GitHub, error) {
+	match := ghRepoRe.FindStringSubmatch(path)
+	if len(match) == 0 {
+		return nil, fmt.Errorf("%s isn't a GitHub repo path", path)
+	}
+	owner, repo, dir := match[1], match[2], strings.Trim(match[3], "/")
+	repoInfo, _, err := client.Repositories.Get(ctx, owner, repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching repo %s/%s: %w", owner, repo, err)
+	}
+
+	r := &GitHub{
+		client:  client,
+		info:    repoInfo,
+		forkOrg: forkOrg,
+		dir:     dir,
+	}
+	if canPush(repoInfo) {
+		r.push = repoInfo
+	} else {
+		log.Printf("no push permission for %s/%s, will push to a fork", owner, repo)
+	}
+	return r, nil
+}
+
+// Checks whether the authenticated user may push to a repository, as reported with the repository.
+func canPush(info *github.Repository) bool {
+	return info.Permissions != nil && (*info.Permissions)["push"]
+}
+
+// Forks the repository into an organization, or the authenticated user's account if org is empty, and waits for
+// the fork to be ready. GitHub returns an existing fork rather than making another.
+func (r *GitHub) fork(ctx context.Context, org string) (*github.Repository, error) {
+	owner, repo := r.ID()
+	forkOwner := org
+	if forkOwner == "" {
+		login, err := r.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		forkOwner = login
+	}
+	log.Printf("forking %s/%s to %s", owner, repo, forkOwner)
+	fork, _, err := r.client.Repositories.CreateFork(ctx, owner, repo, &github.RepositoryCreateForkOptions{Organization: org})
+	if _, ok := err.(*github.AcceptedError); !ok && err != nil {
+		return nil, err
+	}
+	forkName := repo
+	if fork.GetName() != "" {
+		// The fork may be named differently if the owner already has a repository with the upstream's name.
+		forkOwner, forkName = fork.GetOwner().GetLogin(), fork.GetName()
+	}
+
+	// Fork creation is asynchronous, so poll until the fork exists.
+	deadline := time.Now().Add(forkTimeout)
+	for {
+		fork, resp, err := r.client.Repositories.Get(ctx, forkOwner, forkName)
+		if err == nil {
+			if !fork.GetFork() || fork.GetSource().GetFullName() != r.info.GetFullName() &&
+				fork.GetParent().GetFullName() != r.info.GetFullName() {
+				return nil, fmt.Errorf("%s/%s exists but isn't a fork of %s/%s", forkOwner, forkName, owner, repo)
+			}
+			if !canPush(fork) {
+				return nil, fmt.Errorf("no push permission for fork %s/%s", forkOwner, forkName)
+			}
+			log.Printf("pushing to fork %s", fork.GetFullName())
+			return fork, nil
+		} else if resp == nil || resp.StatusCode != 404 || time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed fetching fork %s/%s: %w", forkOwner, forkName, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (r *GitHub) URL() string {
+	return r.info.GetURL()
+}
+
+func (r *GitHub) ID() (owner, repo string) {
+	return r.info.GetOwner().GetLogin(), r.info.GetName()
+}
+
+// The owner and name of the repository to which branches are pushed.
+// This is not known until EditFiles has made any fork required.
+func (r *GitHub) PushID() (owner, repo string) {
+	return r.push.GetOwner().GetLogin(), r.push.GetName()
+}
+
+// Forks the repository, if the token can't push to it and it hasn't been forked already.
+func (r *GitHub) ensurePushable(ctx context.Context) error {
+	if r.push != nil {
+		return nil
+	}
+	owner, repo := r.ID()
+	fork, err := r.fork(ctx, r.forkOrg)
+	if err != nil {
+		return fmt.Errorf("failed forking %s/%s: %w", owner, repo, err)
+	}
+	// Commits are made on the upstream head, which a reused fork may not have.
+	if err := r.syncFork(ctx, fork); err != nil {
+		return fmt.Errorf("failed syncing fork %s with %s/%s (sync or delete the fork): %w",
+			fork.GetFullName(), owner, repo, err)
+	}
+	r.push = fork
+	return nil
+}
+
+// Brings the fork's copy of the upstream default branch up to date with upstream, so the fork has the upstream
+// head commit and tree from which EditFiles makes a commit.
+func (r *GitHub) syncFork(ctx context.Context, fork *github.Repository) error {
+	branch := r.info.GetDefaultBranch()
+	log.Printf("syncing %s branch %s with upstream", fork.GetFullName(), branch)
+	// The merge-upstream endpoint is newer than the client library.
+	endpoint := fmt.Sprintf("repos/%s/%s/merge-upstream", fork.GetOwner().GetLogin(), fork.GetName())
+	req, err := r.client.NewRequest("POST", endpoint, map[string]string{"branch": branch})
+	if err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, req, nil)
+	if gherr, ok := err.(*github.ErrorResponse); ok && gherr.Response.StatusCode == http.StatusConflict {
+		return fmt.Errorf("branch %s has diverged from upstream", branch)
+	}
+	return err
+}
+
+// The head of a pull request from a branch, qualified with the owner of a fork.
+func (r *GitHub) pullHead(branchName string) string {
+	owner, _ := r.PushID()
+	return owner + ":" + branchName
+}
+
+func (r *GitHub) Dir() string {
+	return r.dir
+}
+
+func (r *GitHub) FindGoMod(ctx context.Context, modulePath string) (string, error) {
+	owner, repo := r.ID()
+	head, err := r.headCommit(ctx)
+	if err != nil {
+		return "", err
+	}
+	files := r.newTreeReader(owner, repo, head.GetSHA())
+	return findGoMod(modulePath, r.dir, r.URL(), func(name string) ([]byte, error) {
+		entry, err := files.entry(ctx, name)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		return r.readBlob(ctx, owner, repo, entry)
+	})
+}
+
+// Pushes a commit editing a single file.
+// Returns the commit SHA, or "" if the file isn't modified.
+func (r *GitHub) EditFile(ctx context.Context, name string, edit func([]byte) ([]byte, error)) (string, error) {
+	return r.EditFiles(ctx, []string{name}, func(files map[string][]byte) (map[string][]byte, error) {
+		original, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("no file %s in repo root %s", name, r.URL())
+		}
+		modified, err := edit(original)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{name: modified}, nil
+	})
+}
+
+// Pushes a single commit editing a number of files.
+// The edit function receives the content of each of the named files that exists, and returns new content
+// for those files to be changed or created.
+// Returns the commit SHA, or "" if no file is modified.
+// Files are read from the upstream repository's head, while the commit is created in the repository branches are
+// pushed to. A fork is synced with upstream first, so it has the upstream head.
+func (r *GitHub) EditFiles(ctx context.Context, names []string, edit func(map[string][]byte) (map[string][]byte, error)) (string, error) {
+	owner, repo := r.ID()
+	if err := r.ensurePushable(ctx); err != nil {
+		return "", err
+	}
+	pushOwner, pushRepo := r.PushID()
+	head, err := r.headCommit(ctx)
+	if err != nil {
+		return "", err
+	}
+	files := r.newTreeReader(owner, repo, head.GetSHA())
+	tree, err := files.tree(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	fileEntries := map[string]*github.TreeEntry{}
+	original := map[string][]byte{}
+	for _, name := range names {
+		fileEntry, err := files.entry(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		if fileEntry == nil || fileEntry.GetType() != "blob" {
+			continue
+		}
+		fileEntries[name] = fileEntry
+		content, err := r.readBlob(ctx, owner, repo, fileEntry)
+		if err != nil {
+			return "", fmt.Errorf("failed fetching blob for %s at %s: %w", name, head.GetSHA(), err)
+		}
+		original[name] = content
+	}
+
+	modified, err := edit(original)
+	if err != nil {
+		return "", fmt.Errorf("failed editing files at %s: %w", head.GetSHA(), err)
+	}
+
+	// Push new files as a commit
+	var newEntries []github.TreeEntry
+	for _, name := range names {
+		modifiedContent, ok := modified[name]
+		if !ok {
+			continue
+		}
+		if content, ok := original[name]; ok && bytes.Equal(content, modifiedContent) {
+			continue
+		}
+		newContentString := string(modifiedContent)
+		newEntry := github.TreeEntry{
+			Path:    github.String(name),
+			Mode:    github.String("100644"),
+			Type:    github.String("blob"),
+			Content: &newContentString,
+		}
+		if fileEntry, ok := fileEntries[name]; ok {
+			newEntry.Mode = fileEntry.Mode
+		}
+		newEntries = append(newEntries, newEntry)
+	}
+	if len(newEntries) == 0 {
+		return "", nil
+	}
+
+	//log.Printf("pushing new tree with %d entries", len(newEntries))
+	newTree, _, err := r.client.Git.CreateTree(ctx, pushOwner, pushRepo, tree.GetSHA(), newEntries)
+	if err != nil {
+		// This will fail with request status code 404 if token lacks push permission.
+		return "", fmt.Errorf("failed to create new tree: %w", err)
+	}
+	//log.Printf("new tree %+v", newTree)
+
+	message := commitMessage
+	newCommit := github.Commit{
+		//Author:       nil,
+		//Committer:    nil,
+		Message: &message,
+		Tree:    newTree,
+		Parents: []github.Commit{{SHA: head.SHA}},
+	}
+	log.Printf("pushing commit for tree %s", newTree.GetSHA())
+	commit, _, err := r.client.Git.CreateCommit(ctx, pushOwner, pushRepo, &newCommit)
+	if err != nil {
+		return "", fmt.Errorf("failed to commit new tree: %w", err)
+	}
+	log.Printf("pushed commit %s", commit.GetSHA())
+	return commit.GetSHA(), nil
+}
+
+// Fetches the head commit.
+func (r *GitHub) headCommit(ctx context.Context) (*github.RepositoryCommit, error) {
+	log.Printf("fetching head commit for %s", r.URL())
+	owner, repo := r.ID()
+	commits, _, err := r.client.Repositories.ListCommits(ctx, owner, repo, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing commits: %w", err)
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commits in %s", r.URL())
+	}
+	head := commits[0]
+	log.Printf("head at %s by %s", head.GetSHA(), head.GetAuthor().GetLogin())
+	return head, nil
+}
+
+// Reads entries of a commit's tree by path. Only the (non-recursive) trees of the directories along each path
+// are fetched, since the recursive tree of a large repository is truncated.
+type ghTreeReader struct {
+	client      *github.Client
+	owner, repo string
+	commitSHA   string
+	trees       map[string]*github.Tree // Fetched trees by directory path, "" for the root, nil if no such directory
+}
+
+func (r *GitHub) newTreeReader(owner, repo, commitSHA string) *ghTreeReader {
+	return &ghTreeReader{client: r.client, owner: owner, repo: repo, commitSHA: commitSHA, trees: map[string]*github.Tree{}}
+}
+
+// Returns the tree of a directory, or nil if there is no such directory.
+func (t *ghTreeReader) tree(ctx context.Context, dir string) (*github.Tree, error) {
+	if tree, ok := t.trees[dir]; ok {
+		return tree, nil
+	}
+	sha := t.commitSHA
+	if dir != "" {
+		entry, err := t.entry(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil || entry.GetType() != "tree" {
+			t.trees[dir] = nil
+			return nil, nil
+		}
+		sha = entry.GetSHA()
+	}
+	tree, _, err := t.client.Git.GetTree(ctx, t.owner, t.repo, sha, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching tree of %q at %s: %w", dir, t.commitSHA, err)
+	}
+	if tree.GetTruncated() {
+		return nil, fmt.Errorf("tree of %q at %s is truncated", dir, t.commitSHA)
+	}
+	t.trees[dir] = tree
+	return tree, nil
+}
+
+// Returns the entry for a path, or nil if there is no such entry.
+func (t *ghTreeReader) entry(ctx context.Context, name string) (*github.TreeEntry, error) {
+	dir, base := path.Split(name)
+	tree, err := t.tree(ctx, strings.TrimSuffix(dir, "/"))
+	if err != nil || tree == nil {
+		return nil, err
+	}
+	for i := range tree.Entries {
+		if tree.Entries[i].GetPath() == base {
+			return &tree.Entries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Fetches the content of a file, or nil if the entry isn't a file.
+func (r *GitHub) readBlob(ctx context.Context, owner, repo string, entry *github.TreeEntry) ([]byte, error) {
+	if entry.GetType() != "blob" {
+		return nil, nil
+	}
+	blob, _, err := r.client.Git.GetBlob(ctx, owner, repo, entry.GetSHA())
+	if err != nil {
+		return nil, err
+	}
+	content, err := base64.StdEncoding.DecodeString(blob.GetContent())
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding %s content: %w", entry.GetPath(), err)
+	}
+	return content, nil
+}
+
+// Pushes a branch pointing at a commit.
+// If the branch already exists and its head was pushed by rehab, the branch is force-updated to the commit.
+// A branch with any other head is left alone, and an error returned.
+func (r *GitHub) MakeBranch(ctx context.Context, commitSHA, name string) (string, error) {
+	if err := r.ensurePushable(ctx); err != nil {
+		return "", err
+	}
+	owner, repo := r.PushID()
+	refName := "refs/heads/" + name
+	ref := github.Reference{
+		Ref: &refName,
+		Object: &github.GitObject{
+			SHA: &commitSHA,
+		},
+	}
+	log.Printf("pushing branch %s at %s", refName, commitSHA)
+	_, _, err := r.client.Git.CreateRef(ctx, owner, repo, &ref)
+	if gherr, ok := err.(*github.ErrorResponse); ok && gherr.Message == "Reference already exists" {
+		err = r.updateBranch(ctx, &ref)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to push ref %s %s: %w", refName, commitSHA, err)
+	}
+	return refName, nil
+}
+
+func (r *GitHub) Close() error {
+	return nil
+}
+
+func (r *GitHub) CompareBranch(refName, title, message string) (string, error) {
+	owner, repo := r.ID()
+	head := refName
+	if r.push != r.info {
+		head = r.pullHead(strings.TrimPrefix(refName, "refs/heads/"))
+	}
+	compareURL := fmt.Sprintf("https://github.com/%s/%s/compare/%s...%s?title=%s&body=%s",
+		owner, repo, r.info.GetDefaultBranch(), head, url.QueryEscape(title), url.QueryEscape(message))
+	return compareURL, nil
+}
+
+// Opens a pull request for a branch, or updates the title and body of an open pull request for it.
+// A branch pushed to a fork gets a cross-repository pull request against the upstream repository.
+func (r *GitHub) MakePull(ctx context.Context, refName, title, message string) (string, error) {
+	owner, repo := r.ID()
+	branchName := strings.TrimPrefix(refName, "refs/heads/")
+	existing, _, err := r.client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  r.pullHead(branchName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed listing pull requests for %s: %w", refName, err)
+	}
+	if len(existing) > 0 {
+		number := existing[0].GetNumber()
+		log.Printf("updating pull request %d for %s", number, branchName)
+		pull, _, err := r.client.PullRequests.Edit(ctx, owner, repo, number, &github.PullRequest{
+			Title: &title,
+			Body:  &message,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed updating pull request %d: %w", number, err)
+		}
+		return pull.GetURL(), nil
+	}
+
+	head := refName
+	if r.push != r.info {
+		head = r.pullHead(branchName)
+	}
+	newPull := github.NewPullRequest{
+		Title: &title,
+		Head:  &head,
+		Base:  r.info.DefaultBranch,
+		Body:  &message,
+	}
+	log.Printf("making pull request for %s on %s", newPull.GetHead(), newPull.GetBase())
+	pull, _, err := r.client.PullRequests.Create(ctx, owner, repo, &newPull)
+	if err != nil {
+		return "", fmt.Errorf("failed making pull request ref %s: %w", refName, err)
+	}
+	return pull.GetURL(), nil
+}
+
+// Opens an issue, or updates the title and body of an open issue created by the token owner whose body contains
+// the marker.
+func (r *GitHub) MakeIssue(ctx context.Context, marker, title, body string) (string, error) {
+	owner, repo := r.ID()
+	issue, err := r.findIssue(ctx, marker)
+	if err != nil {
+		return "", err
+	}
+	if issue != nil {
+		number := issue.GetNumber()
+		log.Printf("updating issue %d", number)
+		edited, _, err := r.client.Issues.Edit(ctx, owner, repo, number, &github.IssueRequest{
+			Title: &title,
+			Body:  &body,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed updating issue %d: %w", number, err)
+		}
+		return edited.GetHTMLURL(), nil
+	}
+
+	log.Printf("making issue on %s/%s", owner, repo)
+	issue, _, err = r.client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title: &title,
+		Body:  &body,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed making issue: %w", err)
+	}
+	return issue.GetHTMLURL(), nil
+}
+
+// Closes the open issue created by the token owner whose body contains the marker, replacing its body.
+// Returns the closed issue's URL, or "" if there is no such issue.
+func (r *GitHub) CloseIssue(ctx context.Context, marker, body string) (string, error) {
+	owner, repo := r.ID()
+	issue, err := r.findIssue(ctx, marker)
+	if err != nil || issue == nil {
+		return "", err
+	}
+	number := issue.GetNumber()
+	log.Printf("closing issue %d", number)
+	state := "closed"
+	edited, _, err := r.client.Issues.Edit(ctx, owner, repo, number, &github.IssueRequest{
+		Body:  &body,
+		State: &state,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed closing issue %d: %w", number, err)
+	}
+	return edited.GetHTMLURL(), nil
+}
+
+// Finds the open issue created by the token owner whose body contains the marker, or nil if there is none.
+func (r *GitHub) findIssue(ctx context.Context, marker string) (*github.Issue, error) {
+	owner, repo := r.ID()
+	if !r.info.GetHasIssues() {
+		return nil, fmt.Errorf("issues are disabled for %s/%s", owner, repo)
+	}
+	login, err := r.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	opts := &github.IssueListByRepoOptions{
+		State:       "open",
+		Creator:     login,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		issues, resp, err := r.client.Issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing issues for %s/%s: %w", owner, repo, err)
+		}
+		for _, issue := range issues {
+			if !issue.IsPullRequest() && strings.Contains(issue.GetBody(), marker) {
+				return issue, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// Fetches the login of the token owner.
+func (r *GitHub) login(ctx context.Context) (string, error) {
+	user, _, err := r.client.Users.Get(ctx, "")
+	if err != nil {
+		return "", fmt.Errorf("failed fetching authenticated user: %w", err)
+	}
+	return user.GetLogin(), nil
+}
+
+// Force-updates an existing branch to a new commit, if the branch's current head was pushed by rehab.
+func (r *GitHub) updateBranch(ctx context.Context, ref *github.Reference) error {
+	owner, repo := r.PushID()
+	existing, _, err := r.client.Git.GetRef(ctx, owner, repo, strings.TrimPrefix(ref.GetRef(), "refs/"))
+	if err != nil {
+		return fmt.Errorf("failed fetching existing ref: %w", err)
+	}
+	head, _, err := r.client.Git.GetCommit(ctx, owner, repo, existing.GetObject().GetSHA())
+	if err != nil {
+		return fmt.Errorf("failed fetching existing branch head: %w", err)
+	}
+	if !isRehabCommit(head.GetMessage()) {
+		return fmt.Errorf("branch exists with head %s not pushed by rehab", head.GetSHA())
+	}
+	log.Printf("updating branch %s from %s", ref.GetRef(), head.GetSHA())
+	_, _, err = r.client.Git.UpdateRef(ctx, owner, repo, ref, true)
+	return err
+}
@@ -0,0 +1,472 @@
+package remote
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-github/github"
+)
+
+// An in-process fake of the parts of the GitHub API used by rehab, serving an upstream repository up/lib with
+// a go.mod file (or other files), and the authenticated user "me".
+type fakeGitHub struct {
+	t        *testing.T
+	mu       sync.Mutex
+	repos    map[string]map[string]interface{} // Repositories by full name
+	refs     map[string]string                 // Ref SHAs by repository full name and ref, e.g. "me/lib refs/heads/x"
+	syncCode int                               // Status of merge-upstream responses, if not OK
+	files    map[string]string                 // Files at the head of up/lib by path
+	requests []string                          // Method and path of each request
+	bodies   map[string]map[string]interface{} // Last request body by method and path
+	issues   []map[string]interface{}          // Issues of up/lib
+	pulls    []map[string]interface{}          // Pull requests of up/lib
+}
+
+// Issues listed per page by the fake, so that listing an issue may need several requests.
+const issuesPerPage = 2
+
+func newFakeGitHub(t *testing.T, canPush bool) (*fakeGitHub, *github.Client) {
+	f := &fakeGitHub{
+		t:      t,
+		repos:  map[string]map[string]interface{}{},
+		refs:   map[string]string{},
+		files:  map[string]string{"go.mod": "module github.com/up/lib\n"},
+		bodies: map[string]map[string]interface{}{},
+	}
+	f.repos["up/lib"] = map[string]interface{}{
+		"name": "lib", "full_name": "up/lib", "owner": map[string]string{"login": "up"},
+		"default_branch": "main", "has_issues": true, "url": "https://api.github.com/repos/up/lib",
+		"permissions": map[string]bool{"push": canPush},
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	client := github.NewClient(srv.Client())
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	return f, client
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	request := r.Method + " " + r.URL.Path
+	f.requests = append(f.requests, request)
+	var body map[string]interface{}
+	if r.Method != "GET" && r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("%s: bad body: %s", request, err)
+		}
+		f.bodies[request] = body
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case request == "GET /user":
+		f.respond(w, http.StatusOK, map[string]string{"login": "me"})
+	case r.Method == "GET" && len(parts) == 3 && parts[0] == "repos":
+		if repo, ok := f.repos[parts[1]+"/"+parts[2]]; ok {
+			f.respond(w, http.StatusOK, repo)
+		} else {
+			f.respond(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		}
+	case request == "POST /repos/up/lib/forks":
+		owner := "me"
+		if org := r.URL.Query().Get("organization"); org != "" {
+			owner = org
+		}
+		if _, ok := f.repos[owner+"/lib"]; ok {
+			f.respond(w, http.StatusAccepted, map[string]string{})
+			return
+		}
+		f.repos[owner+"/lib"] = map[string]interface{}{
+			"name": "lib", "full_name": owner + "/lib", "owner": map[string]string{"login": owner},
+			"default_branch": "main", "fork": true, "parent": map[string]string{"full_name": "up/lib"},
+			"permissions": map[string]bool{"push": true},
+		}
+		f.respond(w, http.StatusAccepted, map[string]string{})
+	case r.Method == "POST" && len(parts) == 4 && parts[3] == "merge-upstream":
+		if f.syncCode != 0 {
+			f.respond(w, f.syncCode, map[string]string{"message": "There are merge conflicts"})
+			return
+		}
+		f.respond(w, http.StatusOK, map[string]string{"merge_type": "fast-forward"})
+	case request == "GET /repos/up/lib/commits":
+		f.respond(w, http.StatusOK, []map[string]string{{"sha": "head"}})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/repos/up/lib/git/trees/"):
+		if r.URL.Query().Get("recursive") != "" {
+			f.t.Errorf("%s: recursive tree requested", request)
+		}
+		f.respond(w, http.StatusOK, f.tree(strings.TrimPrefix(r.URL.Path, "/repos/up/lib/git/trees/")))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/repos/up/lib/git/blobs/blob-"):
+		sha := strings.TrimPrefix(r.URL.Path, "/repos/up/lib/git/blobs/")
+		f.respond(w, http.StatusOK, map[string]string{"sha": sha, "encoding": "base64",
+			"content": base64.StdEncoding.EncodeToString([]byte(f.files[f.shaPath(sha)]))})
+	case r.Method == "POST" && len(parts) == 5 && parts[3] == "git" && parts[4] == "trees":
+		f.respond(w, http.StatusCreated, map[string]string{"sha": "tree1"})
+	case r.Method == "POST" && len(parts) == 5 && parts[3] == "git" && parts[4] == "commits":
+		f.respond(w, http.StatusCreated, map[string]string{"sha": "commit1"})
+	case r.Method == "POST" && len(parts) == 5 && parts[3] == "git" && parts[4] == "refs":
+		key := parts[1] + "/" + parts[2] + " " + body["ref"].(string)
+		if _, ok := f.refs[key]; ok {
+			f.respond(w, http.StatusUnprocessableEntity, map[string]string{"message": "Reference already exists"})
+			return
+		}
+		f.refs[key] = body["sha"].(string)
+		f.respond(w, http.StatusCreated, map[string]interface{}{"ref": body["ref"]})
+	case request == "GET /repos/up/lib/pulls":
+		var found []map[string]interface{}
+		for i, p := range f.pulls {
+			if p["head"] == r.URL.Query().Get("head") {
+				found = append(found, map[string]interface{}{"number": i + 1})
+			}
+		}
+		f.respond(w, http.StatusOK, found)
+	case request == "POST /repos/up/lib/pulls":
+		f.pulls = append(f.pulls, body)
+		f.respond(w, http.StatusCreated, map[string]interface{}{
+			"number": len(f.pulls),
+			"url":    fmt.Sprintf("https://api.github.com/repos/up/lib/pulls/%d", len(f.pulls)),
+		})
+	case request == "GET /repos/up/lib/issues":
+		var found []map[string]interface{}
+		for _, i := range f.issues {
+			if i["state"] == r.URL.Query().Get("state") && i["creator"] == r.URL.Query().Get("creator") {
+				found = append(found, i)
+			}
+		}
+		// Pages of issuesPerPage, ignoring the requested page size.
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+		start, end := (page-1)*issuesPerPage, page*issuesPerPage
+		if end < len(found) {
+			next := *r.URL
+			q := next.Query()
+			q.Set("page", strconv.Itoa(page+1))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+		} else {
+			end = len(found)
+		}
+		if start > end {
+			start = end
+		}
+		f.respond(w, http.StatusOK, found[start:end])
+	case request == "POST /repos/up/lib/issues":
+		f.issues = append(f.issues, map[string]interface{}{
+			"number": len(f.issues) + 1, "state": "open", "creator": "me", "title": body["title"], "body": body["body"],
+			"html_url": fmt.Sprintf("https://github.com/up/lib/issues/%d", len(f.issues)+1),
+		})
+		f.respond(w, http.StatusCreated, f.issues[len(f.issues)-1])
+	case r.Method == "PATCH" && len(parts) == 5 && parts[3] == "issues":
+		for _, i := range f.issues {
+			if fmt.Sprint(i["number"]) == parts[4] {
+				for k, v := range body {
+					i[k] = v
+				}
+				f.respond(w, http.StatusOK, i)
+				return
+			}
+		}
+		f.respond(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	default:
+		f.t.Errorf("unexpected request %s", request)
+		f.respond(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+// Lists the entries of a directory of up/lib's head, given the tree SHA: "head" (the commit) or "tree0" for
+// the root, or a SHA of a subdirectory's entry.
+func (f *fakeGitHub) tree(sha string) map[string]interface{} {
+	dir := ""
+	if sha == "head" {
+		sha = "tree0"
+	} else if sha != "tree0" {
+		dir = f.shaPath(sha)
+	}
+	var entries []map[string]string
+	seen := map[string]bool{}
+	for name := range f.files {
+		rest := strings.TrimPrefix(name, dir+"/")
+		if dir == "" {
+			rest = name
+		} else if rest == name {
+			continue
+		}
+		child := strings.SplitN(rest, "/", 2)
+		if seen[child[0]] {
+			continue
+		}
+		seen[child[0]] = true
+		childPath := strings.TrimPrefix(dir+"/"+child[0], "/")
+		if len(child) == 1 {
+			entries = append(entries, map[string]string{"path": child[0], "mode": "100644", "type": "blob", "sha": "blob-" + f.pathSHA(childPath)})
+		} else {
+			entries = append(entries, map[string]string{"path": child[0], "mode": "040000", "type": "tree", "sha": "tree-" + f.pathSHA(childPath)})
+		}
+	}
+	return map[string]interface{}{"sha": sha, "tree": entries}
+}
+
+// Encodes a path in the fake SHA of its entry.
+func (f *fakeGitHub) pathSHA(p string) string {
+	return strings.ReplaceAll(p, "/", ":")
+}
+
+// Decodes the path from the fake SHA of its entry.
+func (f *fakeGitHub) shaPath(sha string) string {
+	return strings.ReplaceAll(strings.SplitN(sha, "-", 2)[1], ":", "/")
+}
+
+func (f *fakeGitHub) respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Counts requests with a method and path.
+func (f *fakeGitHub) count(request string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, r := range f.requests {
+		if r == request {
+			n++
+		}
+	}
+	return n
+}
+
+// Edits go.mod in a repository, then pushes a branch and makes a pull request for it.
+func proposeFakeChange(t *testing.T, r *GitHub) (string, error) {
+	ctx := context.Background()
+	sha, err := r.EditFiles(ctx, []string{"go.mod"}, func(files map[string][]byte) (map[string][]byte, error) {
+		return map[string][]byte{"go.mod": append(files["go.mod"], "require x v1.0.0\n"...)}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	ref, err := r.MakeBranch(ctx, sha, "rehab/x")
+	if err != nil {
+		return "", err
+	}
+	return r.MakePull(ctx, ref, "Title", "Body")
+}
+
+func TestGitHubFindGoMod(t *testing.T) {
+	f, client := newFakeGitHub(t, true)
+	f.files = map[string]string{
+		"go.mod":         "module github.com/up/lib\n",
+		"v3/go.mod":      "module github.com/up/lib/v3\n",
+		"sub/dir/go.mod": "module github.com/up/lib/sub/dir/v2\n",
+		"other/go.mod":   "module example.com/other\n",
+	}
+	ctx := context.Background()
+	for modulePath, expected := range map[string]string{
+		"github.com/up/lib":            "go.mod",
+		"github.com/up/lib/v3":         "v3/go.mod",
+		"github.com/up/lib/sub/dir/v2": "sub/dir/go.mod",
+		// The root go.mod declares the v1 module.
+		"github.com/up/lib/v2":    "",
+		"github.com/up/lib/other": "",
+		"github.com/up/lib/none":  "",
+	} {
+		r, err := openGitHub(ctx, client, modulePath, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := r.FindGoMod(ctx, modulePath)
+		if name != expected || (err != nil) != (expected == "") {
+			t.Errorf("%s: found %q (%v), expected %q", modulePath, name, err, expected)
+		}
+	}
+}
+
+func TestGitHubPushable(t *testing.T) {
+	f, client := newFakeGitHub(t, true)
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proposeFakeChange(t, r); err != nil {
+		t.Fatal(err)
+	}
+	if f.count("POST /repos/up/lib/forks") != 0 || f.count("POST /repos/up/lib/git/commits") != 1 {
+		t.Errorf("requests %v", f.requests)
+	}
+	if f.refs["up/lib refs/heads/rehab/x"] != "commit1" || f.pulls[0]["head"] != "refs/heads/rehab/x" {
+		t.Errorf("refs %v, pulls %v", f.refs, f.pulls)
+	}
+}
+
+func TestGitHubFork(t *testing.T) {
+	f, client := newFakeGitHub(t, false)
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.count("POST /repos/up/lib/forks") != 0 {
+		t.Errorf("forked on opening")
+	}
+	pullURL, err := proposeFakeChange(t, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.count("POST /repos/up/lib/forks") != 1 || f.count("POST /repos/me/lib/merge-upstream") != 1 {
+		t.Errorf("requests %v", f.requests)
+	}
+	if f.bodies["POST /repos/me/lib/merge-upstream"]["branch"] != "main" {
+		t.Errorf("synced %v", f.bodies["POST /repos/me/lib/merge-upstream"])
+	}
+	// The commit is made in the fork, on the upstream head, and the pull request is from the fork.
+	if f.count("POST /repos/me/lib/git/trees") != 1 || f.count("POST /repos/me/lib/git/commits") != 1 ||
+		f.count("POST /repos/up/lib/git/commits") != 0 {
+		t.Errorf("requests %v", f.requests)
+	}
+	if f.bodies["POST /repos/me/lib/git/trees"]["base_tree"] != "tree0" {
+		t.Errorf("tree %v", f.bodies["POST /repos/me/lib/git/trees"])
+	}
+	if f.refs["me/lib refs/heads/rehab/x"] != "commit1" || len(f.refs) != 1 {
+		t.Errorf("refs %v", f.refs)
+	}
+	if pullURL == "" || len(f.pulls) != 1 || f.pulls[0]["head"] != "me:rehab/x" || f.pulls[0]["base"] != "main" {
+		t.Errorf("pull %q %v", pullURL, f.pulls)
+	}
+	compareURL, _ := r.CompareBranch("refs/heads/rehab/x", "T", "B")
+	if !strings.HasPrefix(compareURL, "https://github.com/up/lib/compare/main...me:rehab/x?") {
+		t.Errorf("compare URL %s", compareURL)
+	}
+}
+
+func TestGitHubForkOrg(t *testing.T) {
+	f, client := newFakeGitHub(t, false)
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "myorg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proposeFakeChange(t, r); err != nil {
+		t.Fatal(err)
+	}
+	if f.count("GET /user") != 0 || f.refs["myorg/lib refs/heads/rehab/x"] != "commit1" {
+		t.Errorf("requests %v, refs %v", f.requests, f.refs)
+	}
+}
+
+func TestGitHubForkDiverged(t *testing.T) {
+	f, client := newFakeGitHub(t, false)
+	f.syncCode = http.StatusConflict
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = proposeFakeChange(t, r)
+	if err == nil || !strings.Contains(err.Error(), "diverged") {
+		t.Errorf("error %v, expected divergence", err)
+	}
+	if f.count("POST /repos/me/lib/git/trees") != 0 {
+		t.Errorf("made a tree in a diverged fork")
+	}
+}
+
+func TestGitHubForkNotAFork(t *testing.T) {
+	f, client := newFakeGitHub(t, false)
+	f.repos["me/lib"] = map[string]interface{}{
+		"name": "lib", "full_name": "me/lib", "owner": map[string]string{"login": "me"},
+		"permissions": map[string]bool{"push": true},
+	}
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proposeFakeChange(t, r); err == nil || !strings.Contains(err.Error(), "isn't a fork") {
+		t.Errorf("error %v, expected unrelated repository", err)
+	}
+}
+
+// Adds an issue to the fake.
+func (f *fakeGitHub) addIssue(state, creator, body string, pull bool) {
+	issue := map[string]interface{}{
+		"number": len(f.issues) + 1, "state": state, "creator": creator, "title": "Other", "body": body,
+		"html_url": fmt.Sprintf("https://github.com/up/lib/issues/%d", len(f.issues)+1),
+	}
+	if pull {
+		issue["pull_request"] = map[string]string{"url": "https://api.github.com/repos/up/lib/pulls/1"}
+	}
+	f.issues = append(f.issues, issue)
+}
+
+func TestGitHubMakeIssue(t *testing.T) {
+	f, client := newFakeGitHub(t, false)
+	ctx := context.Background()
+	const marker = "<!-- marker -->"
+	// Issues and pull requests, open or by others, with or without the marker, that aren't the issue to update.
+	f.addIssue("open", "me", "unrelated", false)
+	f.addIssue("open", "other", marker, false)
+	f.addIssue("closed", "me", marker, false)
+	f.addIssue("open", "me", marker, true)
+	f.addIssue("open", "me", "unrelated", false)
+	r, err := openGitHub(ctx, client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No issue to close, until one is made.
+	if closed, err := r.CloseIssue(ctx, marker, "Resolved"); closed != "" || err != nil {
+		t.Errorf("closed %q (%v), expected no issue", closed, err)
+	}
+	created, err := r.MakeIssue(ctx, "<!-- marker -->", "Title", "Body\n"+marker)
+	if err != nil || created != "https://github.com/up/lib/issues/6" || len(f.issues) != 6 {
+		t.Fatalf("made %q (%v), issues %v", created, err, f.issues)
+	}
+	if f.count("POST /repos/up/lib/forks") != 0 {
+		t.Errorf("forked to make an issue")
+	}
+
+	// The issue is found on a later page of issues and updated.
+	f.addIssue("open", "me", "unrelated", false)
+	updated, err := r.MakeIssue(ctx, marker, "New title", "New body\n"+marker)
+	if err != nil || updated != created || len(f.issues) != 7 {
+		t.Fatalf("updated %q (%v), expected %s", updated, err, created)
+	}
+	if issue := f.issues[5]; issue["title"] != "New title" || issue["body"] != "New body\n"+marker {
+		t.Errorf("updated issue %v", issue)
+	}
+	if n := f.count("GET /repos/up/lib/issues"); n != 2+2+2 { // Listing stops at the page with the issue
+		t.Errorf("%d issue list requests", n)
+	}
+
+	// The issue is closed, with a new body, and not updated again.
+	closed, err := r.CloseIssue(ctx, marker, "Resolved\n"+marker)
+	if err != nil || closed != created {
+		t.Fatalf("closed %q (%v), expected %s", closed, err, created)
+	}
+	if issue := f.issues[5]; issue["state"] != "closed" || issue["title"] != "New title" || issue["body"] != "Resolved\n"+marker {
+		t.Errorf("closed issue %v", issue)
+	}
+	if again, err := r.MakeIssue(ctx, marker, "Title", "Body\n"+marker); err != nil || again == created {
+		t.Errorf("made %q (%v), expected a new issue", again, err)
+	}
+}
+
+func TestGitHubIssuesDisabled(t *testing.T) {
+	f, client := newFakeGitHub(t, true)
+	f.repos["up/lib"]["has_issues"] = false
+	r, err := openGitHub(context.Background(), client, "github.com/up/lib", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.MakeIssue(context.Background(), "marker", "Title", "Body"); err == nil {
+		t.Errorf("expected error making an issue with issues disabled")
+	}
+	if _, err := r.CloseIssue(context.Background(), "marker", "Body"); err == nil {
+		t.Errorf("expected error closing an issue with issues disabled")
+	}
+}
@@ -0,0 +1,324 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// A repository hosted on a GitLab server, accessed through its REST API.
+// GitLab creates commits directly on a branch, so EditFiles stages the edits and MakeBranch commits them.
+type GitLab struct {
+	client  *http.Client
+	apiURL  string // Root of the server's API, e.g. https://gitlab.com/api/v4
+	token   string
+	project *gitlabProject
+	dir     string                   // The module's path within the repository, "" for the root
+	staged  map[string]*gitlabCommit // Commits prepared by EditFiles, keyed by identifier
+	nStaged int                      // Number of commits ever staged, for unique identifiers
+}
+
+type gitlabProject struct {
+	ID                int    `json:"id"`
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+	DefaultBranch     string `json:"default_branch"`
+}
+
+type gitlabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+type gitlabAction struct {
+	Action   string `json:"action"` // "create" or "update"
+	FilePath string `json:"file_path"`
+	Content  string `json:"content"`
+}
+
+// A commit staged by EditFiles, to be created by MakeBranch.
+type gitlabCommit struct {
+	startSHA string // The commit edited
+	actions  []gitlabAction
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+// An error response from the GitLab API.
+type gitlabError struct {
+	Status  int
+	Message interface{} // A string, or an object describing errors with fields
+}
+
+func (e *gitlabError) Error() string {
+	return fmt.Sprintf("GitLab API error %d: %v", e.Status, e.Message)
+}
+
+func isNotFound(err error) bool {
+	glerr, ok := err.(*gitlabError)
+	return ok && glerr.Status == http.StatusNotFound
+}
+
+// Opens the GitLab project containing a module, on the server named by the module path's host.
+// Projects may be nested in groups, so the project is the shortest prefix of the module path naming one.
+func OpenGitLab(ctx context.Context, modulePath, token string) (*GitLab, error) {
+	host := strings.SplitN(modulePath, "/", 2)[0]
+	return openGitLab(ctx, http.DefaultClient, "https://"+host+"/api/v4", modulePath, token)
+}
+
+func openGitLab(ctx context.Context, client *http.Client, apiURL, modulePath, token string) (*GitLab, error) {
+	if token == "" {
+		return nil, fmt.Errorf("a GitLab token is required to push to %s", modulePath)
+	}
+	r := &GitLab{
+		client: client,
+		apiURL: apiURL,
+		token:  token,
+		staged: map[string]*gitlabCommit{},
+	}
+	elements := strings.Split(modulePath, "/")
+	for i := 3; i <= len(elements); i++ {
+		projectPath := strings.Join(elements[1:i], "/")
+		project := &gitlabProject{}
+		err := r.call(ctx, "GET", "/projects/"+url.PathEscape(projectPath), nil, project)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed fetching project %s: %w", projectPath, err)
+		}
+		r.project = project
+		r.dir = strings.Join(elements[i:], "/")
+		return r, nil
+	}
+	return nil, fmt.Errorf("no GitLab project found for %s", modulePath)
+}
+
+func (r *GitLab) URL() string {
+	return r.project.WebURL
+}
+
+func (r *GitLab) Dir() string {
+	return r.dir
+}
+
+func (r *GitLab) FindGoMod(ctx context.Context, modulePath string) (string, error) {
+	return findGoMod(modulePath, r.dir, r.URL(), func(name string) ([]byte, error) {
+		content, err := r.readFile(ctx, name, r.project.DefaultBranch)
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return content, err
+	})
+}
+
+func (r *GitLab) EditFiles(ctx context.Context, names []string, edit func(map[string][]byte) (map[string][]byte, error)) (string, error) {
+	log.Printf("fetching head commit for %s", r.URL())
+	head := &gitlabBranch{}
+	if err := r.call(ctx, "GET", r.projectEndpoint("/repository/branches/"+url.PathEscape(r.project.DefaultBranch)), nil, head); err != nil {
+		return "", fmt.Errorf("failed fetching default branch: %w", err)
+	}
+	headSHA := head.Commit.ID
+
+	original := map[string][]byte{}
+	for _, name := range names {
+		content, err := r.readFile(ctx, name, headSHA)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("failed fetching %s at %s: %w", name, headSHA, err)
+		}
+		original[name] = content
+	}
+
+	modified, err := edit(original)
+	if err != nil {
+		return "", fmt.Errorf("failed editing files at %s: %w", headSHA, err)
+	}
+
+	commit := &gitlabCommit{startSHA: headSHA}
+	for _, name := range names {
+		modifiedContent, ok := modified[name]
+		if !ok {
+			continue
+		}
+		action := "create"
+		if content, ok := original[name]; ok {
+			if bytes.Equal(content, modifiedContent) {
+				continue
+			}
+			action = "update"
+		}
+		commit.actions = append(commit.actions, gitlabAction{
+			Action:   action,
+			FilePath: name,
+			Content:  string(modifiedContent),
+		})
+	}
+	if len(commit.actions) == 0 {
+		return "", nil
+	}
+	r.nStaged++
+	id := headSHA + ":" + strconv.Itoa(r.nStaged)
+	r.staged[id] = commit
+	return id, nil
+}
+
+func (r *GitLab) MakeBranch(ctx context.Context, commit, name string) (string, error) {
+	staged, ok := r.staged[commit]
+	if !ok {
+		return "", fmt.Errorf("no staged commit %s", commit)
+	}
+	refName := "refs/heads/" + name
+
+	existing := &gitlabBranch{}
+	err := r.call(ctx, "GET", r.projectEndpoint("/repository/branches/"+url.PathEscape(name)), nil, existing)
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return "", fmt.Errorf("failed fetching branch %s: %w", name, err)
+	}
+	if exists && !isRehabCommit(existing.Commit.Message) {
+		return "", fmt.Errorf("failed to push ref %s: branch exists with head %s not pushed by rehab",
+			refName, existing.Commit.ID)
+	}
+
+	log.Printf("pushing branch %s from %s", refName, staged.startSHA)
+	request := map[string]interface{}{
+		"branch":         name,
+		"start_sha":      staged.startSHA,
+		"commit_message": commitMessage,
+		"actions":        staged.actions,
+		"force":          exists,
+	}
+	created := &struct {
+		ID string `json:"id"`
+	}{}
+	if err := r.call(ctx, "POST", r.projectEndpoint("/repository/commits"), request, created); err != nil {
+		return "", fmt.Errorf("failed to push ref %s: %w", refName, err)
+	}
+	log.Printf("pushed commit %s", created.ID)
+	delete(r.staged, commit)
+	return refName, nil
+}
+
+func (r *GitLab) Close() error {
+	return nil
+}
+
+func (r *GitLab) CompareBranch(refName, title, message string) (string, error) {
+	query := url.Values{}
+	query.Set("merge_request[source_branch]", strings.TrimPrefix(refName, "refs/heads/"))
+	query.Set("merge_request[target_branch]", r.project.DefaultBranch)
+	query.Set("merge_request[title]", title)
+	query.Set("merge_request[description]", message)
+	return r.project.WebURL + "/-/merge_requests/new?" + query.Encode(), nil
+}
+
+func (r *GitLab) MakePull(ctx context.Context, refName, title, message string) (string, error) {
+	branchName := strings.TrimPrefix(refName, "refs/heads/")
+	query := url.Values{}
+	query.Set("state", "opened")
+	query.Set("source_branch", branchName)
+	var existing []*gitlabMergeRequest
+	if err := r.call(ctx, "GET", r.projectEndpoint("/merge_requests?"+query.Encode()), nil, &existing); err != nil {
+		return "", fmt.Errorf("failed listing merge requests for %s: %w", refName, err)
+	}
+
+	mr := &gitlabMergeRequest{}
+	if len(existing) > 0 {
+		iid := existing[0].IID
+		log.Printf("updating merge request %d for %s", iid, branchName)
+		request := map[string]interface{}{"title": title, "description": message}
+		if err := r.call(ctx, "PUT", r.projectEndpoint("/merge_requests/"+strconv.Itoa(iid)), request, mr); err != nil {
+			return "", fmt.Errorf("failed updating merge request %d: %w", iid, err)
+		}
+		return mr.WebURL, nil
+	}
+
+	log.Printf("making merge request for %s on %s", branchName, r.project.DefaultBranch)
+	request := map[string]interface{}{
+		"source_branch": branchName,
+		"target_branch": r.project.DefaultBranch,
+		"title":         title,
+		"description":   message,
+	}
+	if err := r.call(ctx, "POST", r.projectEndpoint("/merge_requests"), request, mr); err != nil {
+		return "", fmt.Errorf("failed making merge request for %s: %w", refName, err)
+	}
+	return mr.WebURL, nil
+}
+
+// Reads the content of a file at some ref.
+func (r *GitLab) readFile(ctx context.Context, name, ref string) ([]byte, error) {
+	endpoint := r.projectEndpoint("/repository/files/" + url.PathEscape(name) + "/raw?ref=" + url.QueryEscape(ref))
+	return r.do(ctx, "GET", endpoint, nil)
+}
+
+func (r *GitLab) projectEndpoint(endpoint string) string {
+	return "/projects/" + strconv.Itoa(r.project.ID) + endpoint
+}
+
+// Calls an API endpoint with a JSON request body (if not nil), decoding the JSON response into result.
+func (r *GitLab) call(ctx context.Context, method, endpoint string, request, result interface{}) error {
+	var body []byte
+	if request != nil {
+		var err error
+		if body, err = json.Marshal(request); err != nil {
+			return err
+		}
+	}
+	response, err := r.do(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(response, result)
+}
+
+// Calls an API endpoint, returning the response body.
+func (r *GitLab) do(ctx context.Context, method, endpoint string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.apiURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", r.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		glerr := &gitlabError{Status: resp.StatusCode, Message: strings.TrimSpace(string(content))}
+		var decoded struct {
+			Message interface{} `json:"message"`
+			Error   interface{} `json:"error"`
+		}
+		if json.Unmarshal(content, &decoded) == nil {
+			if decoded.Message != nil {
+				glerr.Message = decoded.Message
+			} else if decoded.Error != nil {
+				glerr.Message = decoded.Error
+			}
+		}
+		return nil, glerr
+	}
+	return content, nil
+}
@@ -0,0 +1,312 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// An in-process fake of the parts of the GitLab API used by rehab, serving a single project.
+type fakeGitLab struct {
+	t           *testing.T
+	projectPath string
+	mu          sync.Mutex
+	files       map[string]string // Content of files on the default branch, by path
+	branches    map[string]*gitlabBranch
+	commits     []map[string]interface{} // Commit requests received
+	mrs         []map[string]interface{} // Merge requests, with iid, source_branch, title and description
+}
+
+func newFakeGitLab(t *testing.T, projectPath string, files map[string]string) (*fakeGitLab, *httptest.Server) {
+	f := &fakeGitLab{
+		t:           t,
+		projectPath: projectPath,
+		files:       files,
+		branches:    map[string]*gitlabBranch{},
+	}
+	main := &gitlabBranch{Name: "main"}
+	main.Commit.ID = "sha0"
+	main.Commit.Message = "Initial commit"
+	f.branches["main"] = main
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("PRIVATE-TOKEN") != "token" {
+		f.respond(w, http.StatusUnauthorized, map[string]string{"message": "401 Unauthorized"})
+		return
+	}
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v4")
+	projectPrefix := "/projects/1/"
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(path, "/projects/") && !strings.HasPrefix(path, projectPrefix):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, "/projects/"))
+		if name != f.projectPath {
+			f.respond(w, http.StatusNotFound, map[string]string{"message": "404 Project Not Found"})
+			return
+		}
+		f.respond(w, http.StatusOK, &gitlabProject{ID: 1, PathWithNamespace: name,
+			WebURL: "https://gitlab.example.com/" + name, DefaultBranch: "main"})
+	case r.Method == "GET" && strings.HasPrefix(path, projectPrefix+"repository/branches/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(path, projectPrefix+"repository/branches/"))
+		if b, ok := f.branches[name]; ok {
+			f.respond(w, http.StatusOK, b)
+		} else {
+			f.respond(w, http.StatusNotFound, map[string]string{"message": "404 Branch Not Found"})
+		}
+	case r.Method == "GET" && strings.HasPrefix(path, projectPrefix+"repository/files/"):
+		name, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, projectPrefix+"repository/files/"), "/raw"))
+		if r.URL.Query().Get("ref") == "" {
+			f.t.Errorf("no ref reading %s", name)
+		}
+		if content, ok := f.files[name]; ok {
+			_, _ = w.Write([]byte(content))
+		} else {
+			f.respond(w, http.StatusNotFound, map[string]string{"message": "404 File Not Found"})
+		}
+	case r.Method == "POST" && path == projectPrefix+"repository/commits":
+		var req map[string]interface{}
+		f.decode(r, &req)
+		f.commits = append(f.commits, req)
+		branch := req["branch"].(string)
+		if _, exists := f.branches[branch]; exists && req["force"] != true {
+			f.respond(w, http.StatusBadRequest, map[string]string{"message": "A branch called " + branch + " already exists"})
+			return
+		}
+		b := &gitlabBranch{Name: branch}
+		b.Commit.ID = fmt.Sprintf("sha%d", len(f.commits))
+		b.Commit.Message = req["commit_message"].(string)
+		f.branches[branch] = b
+		f.respond(w, http.StatusCreated, map[string]string{"id": b.Commit.ID})
+	case r.Method == "GET" && path == projectPrefix+"merge_requests":
+		var found []map[string]interface{}
+		for _, mr := range f.mrs {
+			if mr["source_branch"] == r.URL.Query().Get("source_branch") && r.URL.Query().Get("state") == "opened" {
+				found = append(found, mr)
+			}
+		}
+		f.respond(w, http.StatusOK, found)
+	case r.Method == "POST" && path == projectPrefix+"merge_requests":
+		var req map[string]interface{}
+		f.decode(r, &req)
+		req["iid"] = len(f.mrs) + 1
+		req["web_url"] = fmt.Sprintf("https://gitlab.example.com/%s/-/merge_requests/%d", f.projectPath, len(f.mrs)+1)
+		f.mrs = append(f.mrs, req)
+		f.respond(w, http.StatusCreated, req)
+	case r.Method == "PUT" && strings.HasPrefix(path, projectPrefix+"merge_requests/"):
+		iid, _ := strconv.Atoi(strings.TrimPrefix(path, projectPrefix+"merge_requests/"))
+		if iid < 1 || iid > len(f.mrs) {
+			f.respond(w, http.StatusNotFound, map[string]string{"message": "404 Not found"})
+			return
+		}
+		var req map[string]interface{}
+		f.decode(r, &req)
+		mr := f.mrs[iid-1]
+		mr["title"], mr["description"] = req["title"], req["description"]
+		f.respond(w, http.StatusOK, mr)
+	default:
+		f.t.Errorf("unexpected request %s %s", r.Method, path)
+		f.respond(w, http.StatusNotFound, map[string]string{"error": "404 Not Found"})
+	}
+}
+
+func (f *fakeGitLab) decode(r *http.Request, v interface{}) {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		f.t.Errorf("bad request body: %s", err)
+	}
+}
+
+func (f *fakeGitLab) respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func openFakeGitLab(t *testing.T, srv *httptest.Server, modulePath string) *GitLab {
+	r, err := openGitLab(context.Background(), srv.Client(), srv.URL+"/api/v4", modulePath, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestGitLabOpenProject(t *testing.T) {
+	_, srv := newFakeGitLab(t, "group/sub/proj", map[string]string{"mod/go.mod": "module x\n"})
+	for _, tc := range []struct {
+		modulePath string
+		dir        string
+		err        bool
+	}{
+		{"gitlab.example.com/group/sub/proj", "", false},
+		{"gitlab.example.com/group/sub/proj/mod", "mod", false},
+		{"gitlab.example.com/group/sub/proj/mod/v2", "mod/v2", false},
+		{"gitlab.example.com/group/other", "", true},
+	} {
+		r, err := openGitLab(context.Background(), srv.Client(), srv.URL+"/api/v4", tc.modulePath, "token")
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tc.modulePath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.modulePath, err)
+			continue
+		}
+		if r.Dir() != tc.dir || r.URL() != "https://gitlab.example.com/group/sub/proj" {
+			t.Errorf("%s: opened %s dir %q, expected dir %q", tc.modulePath, r.URL(), r.Dir(), tc.dir)
+		}
+	}
+
+	if _, err := openGitLab(context.Background(), srv.Client(), srv.URL+"/api/v4", "gitlab.example.com/group/sub/proj", ""); err == nil {
+		t.Errorf("expected error without a token")
+	}
+	if _, err := openGitLab(context.Background(), srv.Client(), srv.URL+"/api/v4", "gitlab.example.com/group/sub/proj", "bad"); err == nil {
+		t.Errorf("expected error with a bad token")
+	}
+}
+
+func TestGitLabFindGoMod(t *testing.T) {
+	_, srv := newFakeGitLab(t, "group/proj", map[string]string{
+		"go.mod":    "module gitlab.example.com/group/proj\n",
+		"v3/go.mod": "module gitlab.example.com/group/proj/v3\n",
+		"x/go.mod":  "module gitlab.example.com/group/proj/x/v2\n",
+	})
+	for modulePath, expected := range map[string]string{
+		"gitlab.example.com/group/proj":      "go.mod",
+		"gitlab.example.com/group/proj/v3":   "v3/go.mod",
+		"gitlab.example.com/group/proj/x/v2": "x/go.mod",
+		// The root go.mod declares the v1 module.
+		"gitlab.example.com/group/proj/v2": "",
+		"gitlab.example.com/group/proj/y":  "",
+	} {
+		name, err := openFakeGitLab(t, srv, modulePath).FindGoMod(context.Background(), modulePath)
+		if name != expected || (err != nil) != (expected == "") {
+			t.Errorf("%s: found %q (%v), expected %q", modulePath, name, err, expected)
+		}
+	}
+}
+
+func TestGitLabEditFiles(t *testing.T) {
+	f, srv := newFakeGitLab(t, "group/proj", map[string]string{"go.mod": "module x\n"})
+	r := openFakeGitLab(t, srv, "gitlab.example.com/group/proj")
+	ctx := context.Background()
+	names := []string{"go.mod", "go.sum"}
+
+	// Writing unchanged content is a no-op.
+	id, err := r.EditFiles(ctx, names, func(files map[string][]byte) (map[string][]byte, error) {
+		if _, ok := files["go.sum"]; ok {
+			t.Errorf("missing file read")
+		}
+		return files, nil
+	})
+	if err != nil || id != "" {
+		t.Fatalf("unchanged edit staged %q (%v)", id, err)
+	}
+
+	// Changes stage a commit, updating existing files and creating others.
+	id, err = r.EditFiles(ctx, names, func(files map[string][]byte) (map[string][]byte, error) {
+		return map[string][]byte{"go.mod": []byte("module x\n\nrequire y v1.0.0\n"), "go.sum": []byte("y v1.0.0 h1:\n")}, nil
+	})
+	if err != nil || id == "" {
+		t.Fatalf("edit staged %q (%v)", id, err)
+	}
+	staged := r.staged[id]
+	if staged.startSHA != "sha0" || len(staged.actions) != 2 ||
+		staged.actions[0].Action != "update" || staged.actions[1].Action != "create" {
+		t.Errorf("staged %+v", staged)
+	}
+	if len(f.commits) != 0 {
+		t.Errorf("EditFiles pushed a commit")
+	}
+}
+
+func TestGitLabMakeBranch(t *testing.T) {
+	f, srv := newFakeGitLab(t, "group/proj", map[string]string{"go.mod": "module x\n"})
+	r := openFakeGitLab(t, srv, "gitlab.example.com/group/proj")
+	ctx := context.Background()
+	stage := func(content string) string {
+		id, err := r.EditFiles(ctx, []string{"go.mod"}, func(files map[string][]byte) (map[string][]byte, error) {
+			return map[string][]byte{"go.mod": []byte(content)}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// A new branch is created.
+	ref, err := r.MakeBranch(ctx, stage("module x // 1\n"), "rehab/x")
+	if err != nil || ref != "refs/heads/rehab/x" {
+		t.Fatalf("made %q (%v)", ref, err)
+	}
+	if len(f.commits) != 1 || f.commits[0]["force"] != false || f.commits[0]["start_sha"] != "sha0" {
+		t.Errorf("commit %v", f.commits)
+	}
+
+	// A branch pushed by rehab is force-updated.
+	if _, err := r.MakeBranch(ctx, stage("module x // 2\n"), "rehab/x"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.commits) != 2 || f.commits[1]["force"] != true {
+		t.Errorf("commit %v", f.commits)
+	}
+
+	// A branch with a head pushed by anyone else is left alone, even with rehab's subject line.
+	for _, message := range []string{"Bump dependencies", "Upgrade module requirements"} {
+		other := &gitlabBranch{Name: "rehab/y"}
+		other.Commit.ID = "human"
+		other.Commit.Message = message
+		f.branches["rehab/y"] = other
+		if _, err := r.MakeBranch(ctx, stage("module x // 3\n"), "rehab/y"); err == nil {
+			t.Errorf("expected error replacing a branch with head %q", message)
+		}
+		if len(f.commits) != 2 {
+			t.Errorf("pushed over a branch with head %q", message)
+		}
+	}
+
+	if _, err := r.MakeBranch(ctx, "unknown", "rehab/z"); err == nil {
+		t.Errorf("expected error for unknown staged commit")
+	}
+}
+
+func TestGitLabMakePull(t *testing.T) {
+	f, srv := newFakeGitLab(t, "group/proj", map[string]string{"go.mod": "module x\n"})
+	r := openFakeGitLab(t, srv, "gitlab.example.com/group/proj")
+	ctx := context.Background()
+
+	mrURL, err := r.MakePull(ctx, "refs/heads/rehab/x", "Title 1", "Body 1")
+	if err != nil || mrURL != "https://gitlab.example.com/group/proj/-/merge_requests/1" {
+		t.Fatalf("made %q (%v)", mrURL, err)
+	}
+	if mr := f.mrs[0]; mr["source_branch"] != "rehab/x" || mr["target_branch"] != "main" || mr["title"] != "Title 1" {
+		t.Errorf("merge request %v", mr)
+	}
+
+	// An open merge request for the branch is updated rather than duplicated.
+	mrURL, err = r.MakePull(ctx, "refs/heads/rehab/x", "Title 2", "Body 2")
+	if err != nil || mrURL != "https://gitlab.example.com/group/proj/-/merge_requests/1" {
+		t.Fatalf("updated %q (%v)", mrURL, err)
+	}
+	if len(f.mrs) != 1 || f.mrs[0]["title"] != "Title 2" || f.mrs[0]["description"] != "Body 2" {
+		t.Errorf("merge requests %v", f.mrs)
+	}
+
+	compareURL, err := r.CompareBranch("refs/heads/rehab/x", "T", "B")
+	if err != nil || !strings.HasPrefix(compareURL, "https://gitlab.example.com/group/proj/-/merge_requests/new?") ||
+		!strings.Contains(compareURL, "merge_request%5Bsource_branch%5D=rehab%2Fx") {
+		t.Errorf("compare URL %q (%v)", compareURL, err)
+	}
+}
@@ -1,186 +1,169 @@
 package remote
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"log"
-	"net/url"
+	"path"
 	"regexp"
+	"strings"
 
-	"github.com/google/go-github/github"
-	"golang.org/x/oauth2"
+	"golang.org/x/mod/modfile"
 )
 
-var ghRepoRe = regexp.MustCompile("github.com/([\\w-]+)/([\\w-]+)(/.*)?")
-
-type Remote struct {
-	client *github.Client
-	info   *github.Repository
-}
-
-func Open(ctx context.Context, path, token string) (*Remote, error) {
-	match := ghRepoRe.FindStringSubmatch(path)
-	if len(match) == 0 {
-		return nil, fmt.Errorf("%s isn't a GitHub repo path", path)
-	}
-	owner, repo := match[1], match[2]
-
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
-
-	repoInfo, _, err := client.Repositories.Get(ctx, owner, repo)
-	if err != nil {
-		return nil, fmt.Errorf("failed fetching repo %s/%s: %w", owner, repo, err)
-	}
-
-
-	return &Remote{
-		client: client,
-		info: repoInfo,
-	}, nil
+// Matches a major version suffix element of a module path.
+var majorVersionRe = regexp.MustCompile("^v[0-9]+$")
+
+// Message for commits pushed by rehab. The trailing line identifies branches that rehab may later replace.
+const commitMessage = "Upgrade module requirements\n\n" + commitMarker
+const commitMarker = "Pushed by Rehab."
+
+// A hosted repository containing a module, to which rehab proposes changes.
+type Remote interface {
+	// The repository's web URL.
+	URL() string
+	// The module's path relative to the repository root, e.g. "sub/dir" for module github.com/owner/repo/sub/dir,
+	// or "" for a module at the repository root.
+	Dir() string
+	// Finds the path of the go.mod file declaring a module in the repository's default branch.
+	// The go.mod file is expected in the module's subdirectory, or for a module path with a major version suffix,
+	// possibly in the parent of that subdirectory.
+	FindGoMod(ctx context.Context, modulePath string) (string, error)
+	// Prepares a single commit editing a number of files on the default branch.
+	// The edit function receives the content of each of the named files that exists, and returns new content
+	// for those files to be changed or created.
+	// Returns an identifier for the commit to pass to MakeBranch, or "" if no file is modified.
+	EditFiles(ctx context.Context, names []string, edit func(map[string][]byte) (map[string][]byte, error)) (string, error)
+	// Pushes a branch with a commit prepared by EditFiles, returning the branch's ref name.
+	// If the branch already exists and its head was pushed by rehab, the branch is force-updated to the commit.
+	// A branch with any other head is left alone, and an error returned.
+	MakeBranch(ctx context.Context, commit, name string) (string, error)
+	// Returns a URL at which a person may open a pull request for a branch, with a title and description.
+	CompareBranch(refName, title, message string) (string, error)
+	// Opens a pull (or merge) request for a branch, or updates the title and description of an open one.
+	// Returns the request's URL, or "" if the host has no pull requests, after printing how to open one.
+	MakePull(ctx context.Context, refName, title, message string) (string, error)
+	// Releases any local resources held for the repository.
+	Close() error
 }
 
-func (r *Remote) URL() string {
-	return r.info.GetURL()
+// A remote with an issue tracker, to which rehab can report stale requirements instead of proposing changes.
+type IssueTracker interface {
+	// Opens an issue, or updates the title and body of the open issue previously made by rehab whose body
+	// contains the marker. Returns the issue's URL.
+	MakeIssue(ctx context.Context, marker, title, body string) (string, error)
+	// Closes the open issue previously made by rehab whose body contains the marker, replacing its body.
+	// Returns the issue's URL, or "" if there is no such issue.
+	CloseIssue(ctx context.Context, marker, body string) (string, error)
 }
 
-func (r *Remote) ID() (owner, repo string) {
-	return r.info.GetOwner().GetLogin(), r.info.GetName()
+// Credentials and hosts for opening remotes.
+type Options struct {
+	GitHubToken string            // GitHub authentication token
+	GitLabToken string            // GitLab authentication token
+	GitLabHosts []string          // Hosts of GitLab servers, in addition to gitlab.com
+	GitRemotes  map[string]string // Plain git URLs for module path prefixes, taking precedence over hosts' APIs
+	ForkOrg     string            // GitHub organization for forks of repositories the token can't push to, if not the token owner
+	Resolver    *Resolver         // Resolves other module paths to their repositories, if set
 }
 
-
-// Pushes a commit editing a single file.
-// Returns the commit SHA, or "" if the file isn't modified.
-func (r *Remote) EditFile(ctx context.Context, name string, edit func([]byte) ([]byte, error)) (string, error) {
-	log.Printf("fetching head commit for %s", r.URL())
-	owner, repo := r.ID()
-	commits, _, err := r.client.Repositories.ListCommits(ctx, owner, repo, nil)
-	if err != nil {
-		return "", fmt.Errorf("failed listing commits: %w", err)
+// Opens the remote repository containing a module. A module matching a plain git URL prefix is opened with git,
+// otherwise the hosting service is chosen by the module path's host. Module paths on other hosts (vanity import
+// paths) are resolved to their repository first, and opened with git if the repository's host is also unknown.
+func Open(ctx context.Context, modulePath string, opts Options) (Remote, error) {
+	longest := ""
+	for prefix := range opts.GitRemotes {
+		if hasPathPrefix(modulePath, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
 	}
-	head := commits[0]
-	log.Printf("head at %s by %s", head.GetSHA(), head.GetAuthor().GetLogin())
-	tree, _, err := r.client.Git.GetTree(ctx, owner, repo, head.GetSHA(), false)
-	if err != nil {
-		return "", fmt.Errorf("failed fetching tree: %w", err)
+	if longest != "" {
+		return OpenGit(ctx, modulePath, longest, opts.GitRemotes[longest])
 	}
 
-	var fileEntry *github.TreeEntry
-	for i := range tree.Entries {
-		if tree.Entries[i].GetPath() == name && tree.Entries[i].GetType() == "blob" {
-			fileEntry = &tree.Entries[i]
-			//log.Println(fileEntry.String())
-			break
-		}
+	if r, ok, err := openHosted(ctx, modulePath, opts); ok {
+		return r, err
 	}
-	if fileEntry == nil {
-		return "", fmt.Errorf("no file %s in repo root %s", name, r.URL())
+	if opts.Resolver == nil {
+		return nil, fmt.Errorf("no known repository host for %s", modulePath)
 	}
-
-	//log.Printf("fetching blob for %s", fileEntry.GetPath())
-	fileBlob, _, err := r.client.Git.GetBlob(ctx, owner, repo, fileEntry.GetSHA())
+	root, err := opts.Resolver.Resolve(ctx, modulePath)
 	if err != nil {
-		return "", fmt.Errorf("failed fetching blob for %s at %s: %w", name, head.GetSHA(), err)
+		return nil, err
 	}
-	content := fileBlob.GetContent()
-	b64 := base64.StdEncoding
-	decoded := make([]byte, b64.DecodedLen(len(content)))
-	decodedLen, err := b64.Decode(decoded, []byte(content))
+	hostPath, err := root.HostPath()
 	if err != nil {
-		return "", fmt.Errorf("failed decoding go.mod content: %w", err)
+		return nil, fmt.Errorf("bad repository URL %s for %s: %w", root.RepoURL, modulePath, err)
 	}
-
-	modifiedContent, err := edit(decoded[:decodedLen])
-	if err != nil {
-		return "", fmt.Errorf("failed editing file %s at %s: %w", name, head.GetSHA(), err)
+	log.Printf("resolved %s to repository %s", modulePath, root.RepoURL)
+	if hostPath != "" {
+		repoPath := path.Join(hostPath, root.Dir(modulePath))
+		if r, ok, err := openHosted(ctx, repoPath, opts); ok {
+			return r, err
+		}
 	}
-
-	if bytes.Equal(decoded[:decodedLen], modifiedContent) {
-		return "", nil
+	if root.VCS != "git" {
+		return nil, fmt.Errorf("unsupported version control system %s for %s", root.VCS, modulePath)
 	}
+	return cloneGit(root.RepoURL, root.Dir(modulePath))
+}
 
-	// Push new file as a commit
-	newContentString := string(modifiedContent)
-	newEntry := github.TreeEntry{
-		Path:    fileEntry.Path,
-		Mode:    fileEntry.Mode,
-		Type:    fileEntry.Type,
-		Content: &newContentString,
-	}
-	//log.Printf("pushing new blob for %s", fileEntry.GetPath())
-	newTree, _, err := r.client.Git.CreateTree(ctx, owner, repo, tree.GetSHA(), []github.TreeEntry{newEntry})
-	if err != nil {
-		// This will fail with request status code 404 if token lacks push permission.
-		return "", fmt.Errorf("failed to create new tree: %w", err)
+// Opens a repository on GitHub or GitLab, if the path's host is one of them.
+func openHosted(ctx context.Context, repoPath string, opts Options) (Remote, bool, error) {
+	host := strings.SplitN(repoPath, "/", 2)[0]
+	if host == "github.com" {
+		r, err := OpenGitHub(ctx, repoPath, opts.GitHubToken, opts.ForkOrg)
+		return r, true, err
+	}
+	for _, h := range append([]string{"gitlab.com"}, opts.GitLabHosts...) {
+		if host == h {
+			r, err := OpenGitLab(ctx, repoPath, opts.GitLabToken)
+			return r, true, err
+		}
 	}
-	//log.Printf("new tree %+v", newTree)
+	return nil, false, nil
+}
 
-	message := "Upgrade module requirements"
-	newCommit := github.Commit{
-		//Author:       nil,
-		//Committer:    nil,
-		Message: &message,
-		Tree:    newTree,
-		Parents: []github.Commit{{SHA: head.SHA}},
-	}
-	log.Printf("pushing commit for tree %s", newTree.GetSHA())
-	commit, _, err := r.client.Git.CreateCommit(ctx, owner, repo, &newCommit)
-	if err != nil {
-		return "",  fmt.Errorf("failed to commit new tree: %w", err)
+// Lists candidate paths of the go.mod file for a module in some repository directory.
+func goModCandidates(dir string) []string {
+	candidates := []string{path.Join(dir, "go.mod")}
+	if majorVersionRe.MatchString(path.Base(dir)) {
+		candidates = append(candidates, path.Join(path.Dir(dir), "go.mod"))
 	}
-	log.Printf("pushed commit %s", commit.GetSHA())
-	return commit.GetSHA(), nil
+	return candidates
 }
 
-func (r *Remote) MakeBranch(ctx context.Context, commitSHA, name string) (string, error) {
-	owner, repo := r.ID()
-	refName := "refs/heads/" + name
-	ref := github.Reference{
-		Ref: &refName,
-		Object: &github.GitObject{
-			SHA: &commitSHA,
-		},
-	}
-	log.Printf("pushing branch %s at %s", refName, commitSHA)
-	_, _, err := r.client.Git.CreateRef(ctx, owner, repo, &ref)
-	if err != nil {
-		if gherr := err.(*github.ErrorResponse); gherr != nil {
-			if gherr.Message == "Reference already exists" {
-				err = nil
-				// FIXME update the ref, or use a unique ref name
-			}
+// Finds the go.mod file declaring a module among the candidate paths for the module's directory in a repository.
+// The read function returns the content of a file, or nil if there is no such file.
+// A candidate declaring another module, such as the parent directory's go.mod for a /vN path that is a
+// directory of the repository's v1 module, is skipped.
+func findGoMod(modulePath, dir, repoURL string, read func(name string) ([]byte, error)) (string, error) {
+	candidates := goModCandidates(dir)
+	for _, c := range candidates {
+		content, err := read(c)
+		if err != nil {
+			return "", err
 		}
+		if content == nil {
+			continue
+		}
+		if declared := modfile.ModulePath(content); declared != modulePath {
+			log.Printf("%s in %s declares module %q, not %s", c, repoURL, declared, modulePath)
+			continue
+		}
+		return c, nil
 	}
-	if err != nil {
-		return "", fmt.Errorf("failed to push ref %s %s: %w", refName, commitSHA, err)
-	}
-	return refName, nil
+	return "", fmt.Errorf("no go.mod for %s in %s, tried %s", modulePath, repoURL, strings.Join(candidates, ", "))
 }
 
-func (r *Remote) CompareBranch(refName, title, message string) (string, error) {
-	owner, repo := r.ID()
-	compareURL := fmt.Sprintf("https://github.com/%s/%s/compare/%s...%s?title=%s&body=%s",
-		owner, repo, r.info.GetDefaultBranch(), refName, url.QueryEscape(title), url.QueryEscape(message))
-	return compareURL, nil
+// Checks whether a module path is prefix, or has prefix as a leading sequence of elements.
+func hasPathPrefix(modulePath, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return modulePath == prefix || strings.HasPrefix(modulePath, prefix+"/")
 }
 
-func (r *Remote) MakePull(ctx context.Context, refName, title, message string) (string, error) {
-	owner, repo := r.ID()
-	newPull := github.NewPullRequest{
-		Title: &title,
-		Head:  &refName,
-		Base:  r.info.DefaultBranch,
-		Body:  &message,
-	}
-	log.Printf("making pull request for %s on %s", newPull.GetHead(), newPull.GetBase())
-	pull, _, err := r.client.PullRequests.Create(ctx, owner, repo, &newPull)
-	if err != nil {
-		return "", fmt.Errorf("failed making pull request ref %s: %w", refName, err)
-	}
-	return pull.GetURL(), nil
-}
\ No newline at end of file
+// Checks whether a commit message is one used by rehab, which includes the marker.
+// A message without it, even one matching rehab's subject line, may be from a person.
+func isRehabCommit(message string) bool {
+	return strings.Contains(message, commitMarker)
+}
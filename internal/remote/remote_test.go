@@ -0,0 +1,18 @@
+package remote
+
+import "testing"
+
+func TestIsRehabCommit(t *testing.T) {
+	for message, expected := range map[string]bool{
+		commitMessage: true,
+		"Update retracted module requirements\n\nSome body.\n\n" + commitMarker: true,
+		"Upgrade module requirements":                                           false,
+		"Upgrade module requirements\n\nBy hand this time.":                     false,
+		"Bump dependencies": false,
+		"":                  false,
+	} {
+		if actual := isRehabCommit(message); actual != expected {
+			t.Errorf("%q: rehab commit %v, expected %v", message, actual, expected)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The repository root of a module path, as declared by a go-import meta tag.
+// See https://go.dev/ref/mod#vcs-find
+type RepoRoot struct {
+	Prefix  string    // The import path prefix corresponding to the repository root
+	VCS     string    // The version control system, e.g. "git"
+	RepoURL string    // The repository URL
+	Time    time.Time // When the root was resolved, for expiring cached roots
+}
+
+// The path of the repository as its host and path, e.g. github.com/owner/repo for https://github.com/owner/repo.git,
+// or "" for a repository with no host, such as a file:// URL.
+// Git's scp-like syntax (git@github.com:owner/repo) isn't supported, since it isn't a URL.
+func (r *RepoRoot) HostPath() (string, error) {
+	if !strings.Contains(r.RepoURL, "://") && strings.Contains(r.RepoURL, ":") {
+		return "", fmt.Errorf("unsupported scp-style repository URL %s, expected a URL such as ssh://user@host/path",
+			r.RepoURL)
+	}
+	u, err := url.Parse(r.RepoURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" {
+		return "", fmt.Errorf("repository URL %s has no scheme", r.RepoURL)
+	}
+	if u.Host == "" {
+		return "", nil
+	}
+	return u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git"), nil
+}
+
+// The directory of a module path within the repository, "" for the root.
+func (r *RepoRoot) Dir(modulePath string) string {
+	return strings.Trim(strings.TrimPrefix(modulePath, r.Prefix), "/")
+}
+
+// Resolves module paths to their repositories with the go-get=1 protocol, caching the results.
+type Resolver struct {
+	Client    *http.Client
+	Scheme    string            // URL scheme for go-get requests, "https" except for testing
+	CacheFile string            // File caching resolved roots between runs, or "" for none
+	TTL       time.Duration     // Time after which cached roots are resolved again
+	Overrides map[string]string // Repository URLs for module path prefixes, used instead of resolving
+
+	cache map[string]*RepoRoot // keyed by prefix
+}
+
+// Creates a resolver with a cache in the user's cache directory.
+func NewResolver(overrides map[string]string) *Resolver {
+	cacheFile := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		cacheFile = filepath.Join(dir, "rehab", "go-import.json")
+	}
+	return &Resolver{
+		Client:    http.DefaultClient,
+		Scheme:    "https",
+		CacheFile: cacheFile,
+		TTL:       24 * time.Hour,
+		Overrides: overrides,
+	}
+}
+
+// Resolves the repository root of a module path, from overrides, the cache, or a go-get request.
+func (r *Resolver) Resolve(ctx context.Context, modulePath string) (*RepoRoot, error) {
+	longest := ""
+	for prefix := range r.Overrides {
+		if hasPathPrefix(modulePath, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest != "" {
+		return &RepoRoot{Prefix: strings.TrimSuffix(longest, "/"), VCS: "git", RepoURL: r.Overrides[longest]}, nil
+	}
+
+	r.loadCache()
+	var cached *RepoRoot
+	for prefix, root := range r.cache {
+		if hasPathPrefix(modulePath, prefix) && time.Since(root.Time) < r.TTL &&
+			(cached == nil || len(prefix) > len(cached.Prefix)) {
+			cached = root
+		}
+	}
+	if cached != nil {
+		return cached, nil
+	}
+
+	root, err := r.fetch(ctx, modulePath)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[root.Prefix] = root
+	r.saveCache()
+	return root, nil
+}
+
+// Fetches the go-import meta tag for a module path.
+func (r *Resolver) fetch(ctx context.Context, modulePath string) (*RepoRoot, error) {
+	requestURL := fmt.Sprintf("%s://%s?go-get=1", r.Scheme, modulePath)
+	log.Printf("resolving %s", requestURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed resolving %s: %w", modulePath, err)
+	}
+	defer resp.Body.Close()
+	// As for the go command, the body is parsed even for error statuses, since some servers respond
+	// to unknown paths with a valid meta tag.
+	roots, err := parseMetaGoImports(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing go-import meta tags for %s: %w", modulePath, err)
+	}
+	var found *RepoRoot
+	for _, root := range roots {
+		if root.VCS == "mod" || !hasPathPrefix(modulePath, root.Prefix) {
+			continue
+		}
+		if found != nil && found.Prefix != root.Prefix {
+			return nil, fmt.Errorf("multiple go-import meta tags match %s", modulePath)
+		}
+		found = root
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no go-import meta tag for %s", modulePath)
+	}
+	found.Time = time.Now()
+	return found, nil
+}
+
+func (r *Resolver) loadCache() {
+	if r.cache != nil {
+		return
+	}
+	r.cache = map[string]*RepoRoot{}
+	if r.CacheFile == "" {
+		return
+	}
+	content, err := ioutil.ReadFile(r.CacheFile)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(content, &r.cache); err != nil {
+		log.Printf("ignoring bad cache file %s: %s", r.CacheFile, err)
+		r.cache = map[string]*RepoRoot{}
+	}
+}
+
+func (r *Resolver) saveCache() {
+	if r.CacheFile == "" {
+		return
+	}
+	content, err := json.MarshalIndent(r.cache, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(r.CacheFile), 0755)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(r.CacheFile, content, 0644)
+	}
+	if err != nil {
+		log.Printf("failed writing cache file %s: %s", r.CacheFile, err)
+	}
+}
+
+// Parses go-import meta tags from an HTML page, stopping at the end of the head.
+// Derived from the go command's parseMetaGoImports.
+func parseMetaGoImports(body io.Reader) ([]*RepoRoot, error) {
+	d := xml.NewDecoder(body)
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "utf-8", "ascii":
+			return input, nil
+		default:
+			return nil, fmt.Errorf("can't decode XML document using charset %q", charset)
+		}
+	}
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var roots []*RepoRoot
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(roots) > 0 {
+				break
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			break
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			break
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 3 {
+			roots = append(roots, &RepoRoot{Prefix: f[0], VCS: f[1], RepoURL: f[2]})
+		}
+	}
+	return roots, nil
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMetaGoImports(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		html     string
+		expected []RepoRoot
+	}{
+		{
+			name: "single tag",
+			html: `<html><head><meta name="go-import" content="example.com/x git https://github.com/owner/x"></head></html>`,
+			expected: []RepoRoot{
+				{Prefix: "example.com/x", VCS: "git", RepoURL: "https://github.com/owner/x"},
+			},
+		},
+		{
+			name: "multiple tags and mod entries",
+			html: `<!DOCTYPE html><html><head>
+<meta charset="utf-8">
+<meta name="go-import" content="example.com/x mod https://proxy.example.com">
+<meta name="go-import" content="example.com/x git https://github.com/owner/x.git">
+<META NAME="go-import" CONTENT="example.com/y hg https://hg.example.com/y">
+<meta name="go-source" content="example.com/x https://github.com/owner/x _ _">
+</head></html>`,
+			expected: []RepoRoot{
+				{Prefix: "example.com/x", VCS: "mod", RepoURL: "https://proxy.example.com"},
+				{Prefix: "example.com/x", VCS: "git", RepoURL: "https://github.com/owner/x.git"},
+				{Prefix: "example.com/y", VCS: "hg", RepoURL: "https://hg.example.com/y"},
+			},
+		},
+		{
+			name: "stops at end of head",
+			html: `<html><head><meta name="go-import" content="example.com/x git https://a"></head>
+<body><meta name="go-import" content="example.com/z git https://b"></body></html>`,
+			expected: []RepoRoot{
+				{Prefix: "example.com/x", VCS: "git", RepoURL: "https://a"},
+			},
+		},
+		{
+			name: "stops at body",
+			html: `<html><body><meta name="go-import" content="example.com/z git https://b"></body></html>`,
+		},
+		{
+			name: "malformed content",
+			html: `<html><head><meta name="go-import" content="example.com/x git"></head></html>`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			roots, err := parseMetaGoImports(strings.NewReader(tc.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []RepoRoot
+			for _, r := range roots {
+				actual = append(actual, *r)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("parsed %+v, expected %+v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// Serves go-import meta tags, each with a prefix relative to the test server's host, counting requests.
+func newGoImportServer(t *testing.T, tags ...string) (*httptest.Server, *int) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("go-get") != "1" {
+			t.Errorf("request without go-get=1: %s", r.URL)
+		}
+		fmt.Fprint(w, "<html><head>")
+		for _, tag := range tags {
+			fmt.Fprintf(w, `<meta name="go-import" content="%s/%s">`, r.Host, tag)
+		}
+		fmt.Fprint(w, "</head></html>")
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func testResolver(srv *httptest.Server, cacheFile string) *Resolver {
+	return &Resolver{Client: srv.Client(), Scheme: "http", CacheFile: cacheFile, TTL: time.Hour}
+}
+
+func TestResolve(t *testing.T) {
+	srv, _ := newGoImportServer(t,
+		"x mod https://proxy.example.com",
+		"x git https://github.com/owner/x.git",
+		"other git https://github.com/owner/other")
+	host := strings.TrimPrefix(srv.URL, "http://")
+	root, err := testResolver(srv, "").Resolve(context.Background(), host+"/x/sub/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostPath, err := root.HostPath()
+	if root.Prefix != host+"/x" || root.VCS != "git" || hostPath != "github.com/owner/x" || err != nil {
+		t.Errorf("resolved %+v (%s, %v)", root, hostPath, err)
+	}
+	if dir := root.Dir(host + "/x/sub/v2"); dir != "sub/v2" {
+		t.Errorf("dir %q", dir)
+	}
+
+	if _, err := testResolver(srv, "").Resolve(context.Background(), host+"/none"); err == nil {
+		t.Errorf("expected error for path with no matching tag")
+	}
+}
+
+func TestResolveConflictingTags(t *testing.T) {
+	srv, _ := newGoImportServer(t,
+		"x git https://github.com/owner/x",
+		"x/y git https://github.com/owner/y")
+	host := strings.TrimPrefix(srv.URL, "http://")
+	if _, err := testResolver(srv, "").Resolve(context.Background(), host+"/x/y"); err == nil {
+		t.Errorf("expected error for multiple matching prefixes")
+	}
+}
+
+func TestResolveOverrides(t *testing.T) {
+	r := &Resolver{Overrides: map[string]string{
+		"example.com/y":     "https://gitlab.com/group/y",
+		"example.com/y/sub": "https://github.com/owner/sub.git",
+		"example.com/yy":    "https://github.com/owner/yy",
+	}}
+	for modulePath, expected := range map[string]string{
+		"example.com/y":          "gitlab.com/group/y ",
+		"example.com/y/z":        "gitlab.com/group/y z",
+		"example.com/y/sub/v2":   "github.com/owner/sub v2",
+		"example.com/yy/x":       "github.com/owner/yy x",
+		"example.com/y/subtle/x": "gitlab.com/group/y subtle/x",
+	} {
+		root, err := r.Resolve(context.Background(), modulePath)
+		if err != nil {
+			t.Errorf("%s: %s", modulePath, err)
+			continue
+		}
+		hostPath, _ := root.HostPath()
+		if actual := hostPath + " " + root.Dir(modulePath); actual != expected {
+			t.Errorf("%s: resolved %q, expected %q", modulePath, actual, expected)
+		}
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	srv, requests := newGoImportServer(t, "x git https://github.com/owner/x")
+	host := strings.TrimPrefix(srv.URL, "http://")
+	cacheFile := filepath.Join(t.TempDir(), "rehab", "go-import.json")
+	ctx := context.Background()
+
+	// Resolved roots are cached in memory, and in the cache file for other resolvers.
+	r := testResolver(srv, cacheFile)
+	for _, p := range []string{host + "/x", host + "/x/a", host + "/x/b"} {
+		if _, err := r.Resolve(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *requests != 1 {
+		t.Errorf("%d requests, expected 1", *requests)
+	}
+	if content, err := ioutil.ReadFile(cacheFile); err != nil || !strings.Contains(string(content), "github.com/owner/x") {
+		t.Errorf("cache file %q (%v)", content, err)
+	}
+	root, err := testResolver(srv, cacheFile).Resolve(ctx, host+"/x/c")
+	if err != nil || root.RepoURL != "https://github.com/owner/x" || *requests != 1 {
+		t.Errorf("resolved %+v (%v) with %d requests, expected cached root", root, err, *requests)
+	}
+
+	// Expired roots are resolved again.
+	expiring := testResolver(srv, cacheFile)
+	expiring.TTL = 0
+	if _, err := expiring.Resolve(ctx, host+"/x"); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 2 {
+		t.Errorf("%d requests, expected 2 after expiry", *requests)
+	}
+
+	// A corrupt cache file is ignored.
+	if err := ioutil.WriteFile(cacheFile, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testResolver(srv, cacheFile).Resolve(ctx, host+"/x"); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != 3 {
+		t.Errorf("%d requests, expected 3 with a corrupt cache", *requests)
+	}
+}
+
+func TestRepoRootHostPath(t *testing.T) {
+	for repoURL, expected := range map[string]string{
+		"https://github.com/owner/repo":      "github.com/owner/repo",
+		"https://github.com/owner/repo.git/": "github.com/owner/repo",
+		"ssh://git@gitlab.com/group/sub/x":   "gitlab.com/group/sub/x",
+		"file:///srv/git/repo.git":           "",
+	} {
+		hostPath, err := (&RepoRoot{RepoURL: repoURL}).HostPath()
+		if err != nil || hostPath != expected {
+			t.Errorf("%s: host path %q (%v), expected %q", repoURL, hostPath, err, expected)
+		}
+	}
+	for _, repoURL := range []string{"git@github.com:owner/repo.git", "github.com/owner/repo"} {
+		if hostPath, err := (&RepoRoot{RepoURL: repoURL}).HostPath(); err == nil {
+			t.Errorf("%s: host path %q, expected error", repoURL, hostPath)
+		}
+	}
+}
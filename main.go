@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/anorth/rehab/internal/cmd"
+	"github.com/anorth/rehab/internal/config"
 	"github.com/urfave/cli/v2"
 )
 
@@ -15,6 +18,7 @@ func main() {
 		MinimumUpgrade:   false,
 		BranchPrefix:     "rehab/",
 		MakePullRequests: false,
+		ReplacedPolicy:   cmd.ReplacedReport,
 	}
 	verbose := false
 	allFlag := &cli.BoolFlag{
@@ -37,6 +41,79 @@ func main() {
 		Required:    false,
 		Destination: &rehab.MinimumUpgrade,
 	}
+	ofFlag := &cli.StringFlag{
+		Name:     "of",
+		Usage:    "upgrades requirements on a module (`path[@version]`) older than the version (default latest) across the dependency tree",
+		Required: false,
+	}
+	dryRunFlag := &cli.BoolFlag{
+		Name:        "dry-run",
+		Aliases:     nil,
+		Usage:       "prints proposed go.mod changes rather than pushing them (requires no token)",
+		Required:    false,
+		Destination: &rehab.DryRun,
+	}
+	majorFlag := &cli.BoolFlag{
+		Name:        "major",
+		Usage:       "with --local, also upgrades to new major versions of requirements, rewriting imports",
+		Required:    false,
+		Destination: &rehab.MajorUpgrade,
+	}
+	forkOrgFlag := &cli.StringFlag{
+		Name:        "fork-org",
+		Usage:       "GitHub `organization` for forks of repositories the token can't push to (default the token owner)",
+		Required:    false,
+		Destination: &rehab.ForkOrg,
+	}
+	issueFlag := &cli.BoolFlag{
+		Name:        "issue",
+		Usage:       "opens or updates a GitHub issue listing each consumer's stale requirements, rather than pushing upgrades",
+		Required:    false,
+		Destination: &rehab.FileIssues,
+	}
+	localFlag := &cli.BoolFlag{
+		Name:     "local",
+		Usage:    "applies upgrades to the main module in the local workspace and tidies it, rather than pushing them",
+		Required: false,
+	}
+	replacedFlag := &cli.StringFlag{
+		Name:        "replaced",
+		Usage:       "policy for requirements on modules replaced by the main module: skip, report or upgrade",
+		Value:       cmd.ReplacedReport,
+		Required:    false,
+		Destination: &rehab.ReplacedPolicy,
+	}
+	checkReplaced := func(c *cli.Context) error {
+		switch rehab.ReplacedPolicy {
+		case cmd.ReplacedSkip, cmd.ReplacedReport, cmd.ReplacedUpgrade:
+			return nil
+		}
+		return fmt.Errorf("unknown policy for replaced modules: %s", rehab.ReplacedPolicy)
+	}
+	// Checks flags and loads the configuration file of the workspace named by the first argument.
+	prepare := func(c *cli.Context) error {
+		if err := checkReplaced(c); err != nil {
+			return err
+		}
+		cfg, err := config.Load(c.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		rehab.Config = cfg
+		return nil
+	}
+	importedOnlyFlag := &cli.BoolFlag{
+		Name:        "imported-only",
+		Usage:       "considers only stale requirements where the consumer's packages import the required module",
+		Required:    false,
+		Destination: &rehab.ImportedOnly,
+	}
+	maxBumpFlag := &cli.StringFlag{
+		Name:        "max-bump",
+		Usage:       "upgrades only to the highest version within a `patch` or minor version bump",
+		Required:    false,
+		Destination: &rehab.MaxBump,
+	}
 	verboseFlag := &cli.BoolFlag{
 		Name:        "verbose",
 		Aliases:     []string{"v"},
@@ -46,23 +123,61 @@ func main() {
 		Name:     "rehab",
 		HelpName: "rehab",
 		Usage:    "treatment for dependencies",
+
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:        "token",
 				Aliases:     nil,
 				Usage:       "GitHub authentication token",
 				EnvVars:     []string{"GITHUB_TOKEN"},
-				Required:    true,
+				Required:    false,
 				Destination: &rehab.GitHubToken,
 			},
+			&cli.StringFlag{
+				Name:        "gitlab-token",
+				Usage:       "GitLab authentication token",
+				EnvVars:     []string{"GITLAB_TOKEN"},
+				Required:    false,
+				Destination: &rehab.GitLabToken,
+			},
+			&cli.StringSliceFlag{
+				Name:     "git-remote",
+				Usage:    "pushes modules under a path prefix to plain git repositories under a URL (`prefix=url`, may be repeated)",
+				Required: false,
+			},
+			&cli.StringSliceFlag{
+				Name:     "gitlab-host",
+				Usage:    "host of a self-hosted GitLab server, for modules hosted there (may be repeated)",
+				EnvVars:  []string{"GITLAB_HOSTS"},
+				Required: false,
+			},
 			verboseFlag,
 		},
 		Commands: []*cli.Command{
 			{
-				Name:  "show",
-				Usage: "shows requirement updates available for a module",
+				Name:   "show",
+				Usage:  "shows requirement updates available for a module",
+				Before: prepare,
 				Flags: []cli.Flag{
 					allFlag,
+					&cli.BoolFlag{
+						Name:     "deprecated",
+						Usage:    "also shows deprecated modules and the modules requiring them",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:     "major",
+						Usage:    "also shows newer major versions of required modules",
+						Required: false,
+					},
+					&cli.BoolFlag{
+						Name:        "metrics",
+						Usage:       "shows releases and libyears behind for each stale requirement, and totals per consumer",
+						Required:    false,
+						Destination: &rehab.Metrics,
+					},
+					replacedFlag,
+					importedOnlyFlag,
 					verboseFlag,
 				},
 				Action: func(c *cli.Context) error {
@@ -75,16 +190,30 @@ func main() {
 					if !c.Bool("verbose") {
 						log.SetOutput(io.Discard)
 					}
-					return rehab.Show(root, all)
+					return rehab.Show(root, cmd.ShowOptions{
+						All:        all,
+						Deprecated: c.Bool("deprecated"),
+						Major:      c.Bool("major"),
+					})
 				},
 			},
 			{
-				Name:  "upgrade",
-				Usage: "makes a pull request updating a module's requirements",
+				Name:   "upgrade",
+				Usage:  "makes a pull request updating a module's requirements",
+				Before: prepare,
 				Flags: []cli.Flag{
 					allFlag,
 					pullFlag,
 					minimumFlag,
+					ofFlag,
+					dryRunFlag,
+					forkOrgFlag,
+					issueFlag,
+					localFlag,
+					majorFlag,
+					maxBumpFlag,
+					replacedFlag,
+					importedOnlyFlag,
 					verboseFlag,
 				},
 				Action: func(c *cli.Context) error {
@@ -93,10 +222,75 @@ func main() {
 					}
 					root := c.Args().Get(0)
 					all := c.Bool("all")
+					of := c.String("of")
+					if !c.Bool("verbose") {
+						log.SetOutput(io.Discard)
+					}
+					if c.Bool("local") {
+						if rehab.FileIssues {
+							return fmt.Errorf("issues can't be filed with --local")
+						} else if all {
+							return fmt.Errorf("--all can't be used with --local, which upgrades only the main modules")
+						}
+						return rehab.ApplyLocal(root, of)
+					} else if rehab.MajorUpgrade {
+						return fmt.Errorf("major version upgrades are supported only with --local")
+					}
+					rehab.GitLabHosts = c.StringSlice("gitlab-host")
+					rehab.GitRemotes = map[string]string{}
+					for _, mapping := range c.StringSlice("git-remote") {
+						parts := strings.SplitN(mapping, "=", 2)
+						if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+							return fmt.Errorf("bad git remote %s, expected prefix=url", mapping)
+						}
+						rehab.GitRemotes[parts[0]] = parts[1]
+					}
+					return rehab.Propose(c.Context, root, all, of)
+				},
+			},
+			{
+				Name:  "plan",
+				Usage: "plans rounds of upgrades and releases to bring the whole dependency graph to head",
+				Flags: []cli.Flag{
+					replacedFlag,
+					importedOnlyFlag,
+					verboseFlag,
+				},
+				Before: prepare,
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 1 {
+						cli.ShowSubcommandHelpAndExit(c, 1)
+					}
+					root := c.Args().Get(0)
+					if !c.Bool("verbose") {
+						log.SetOutput(io.Discard)
+					}
+					return rehab.Plan(root)
+				},
+			},
+			{
+				Name:      "why",
+				Usage:     "shows the requirement paths by which a module (`path[@version]`) is in the build",
+				ArgsUsage: "<module>[@version] <path to workspace>",
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:     "max-paths",
+						Usage:    "limits the number of paths shown (0 for no limit)",
+						Value:    100,
+						Required: false,
+					},
+					verboseFlag,
+				},
+				Action: func(c *cli.Context) error {
+					if c.NArg() != 2 {
+						cli.ShowSubcommandHelpAndExit(c, 1)
+					}
+					target := c.Args().Get(0)
+					root := c.Args().Get(1)
 					if !c.Bool("verbose") {
 						log.SetOutput(io.Discard)
 					}
-					return rehab.Propose(c.Context, root, all)
+					return rehab.Why(root, target, c.Int("max-paths"))
 				},
 			},
 		},
@@ -105,6 +299,8 @@ func main() {
 	log.SetFlags(0)
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		// The log may have been discarded.
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }